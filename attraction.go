@@ -1,17 +1,35 @@
 package main
 
 import (
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 	"regexp"
+	"strconv"
 	"strings"
 )
 
 var viable_categories = []string{"nature", "heritage", "museums"}
 
+// Number of bytes values must be longer than.
+const (
+	min_info_length = 30
+	min_name_length = 3
+	min_city_length = 3
+)
+
+// Bounds of coordinates in Lithuania.
+const (
+	min_latitude  = 53.53
+	max_latitude  = 56.27
+	min_longitude = 20.56
+	max_longitude = 26.5
+)
+
 var regex_hours = regexp.MustCompile("([0-9]{2}:[0-9]{2}-[0-9]{2}:[0-9]{2})")
 
 // Regex that matches a-z and lithuanian characters
@@ -26,47 +44,107 @@ var regex_lith_chars_spaces = regexp.MustCompile("([\u0104-\u0105])|([\u010C-\u0
 // it it occured.
 func validateAttraction(writer http.ResponseWriter, request *http.Request) (*RawAttraction, error) {
 
-	var ra RawAttraction
+	var body interface{}
 
 	// see utils.go
-	code, msg := validateJson(request, &ra)
+	if err := validateJson(request, &body); err != nil {
+		return nil, err
+	}
+
+	// Clients of the deprecated alias were written when names were matched in any case, see versions.go
+	if isLegacy(request) {
+		body = attraction_schema.foldKeys(body)
+	}
+
+	return parseAttraction(body)
+}
+
+// Function takes in a decoded json value and checks it against the attraction schema and the
+// rules the schema can't express. Returns a reference to RawAttraction if it's valid and
+// ValidationErrors with every invalid value otherwise.
+func parseAttraction(body interface{}) (*RawAttraction, error) {
+
+	// see schema.go
+	errs := attraction_schema.check(body)
+
+	// Nothing else can be checked if the body isn't an object.
+	if _, ok := body.(map[string]interface{}); !ok {
+		return nil, errs
+	}
+
+	// Values of wrong types are skipped and already reported by the schema.
+	var ra RawAttraction
+	data, _ := json.Marshal(body)
+	json.Unmarshal(data, &ra)
+
+	// Values the schema found invalid, and values inside them, aren't reported twice.
+	var invalid ValidationErrors
+	if errors.As(ra.validate(), &invalid) {
+		for _, e := range invalid {
+			if !errs.covers(e.Path) {
+				errs = append(errs, e)
+			}
+		}
+	}
 
-	if code != http.StatusOK {
-		return nil, errors.New(msg)
+	if len(errs) > 0 {
+		return nil, errs
 	}
 
-	if len(ra.Description.Info) <= 30 {
-		return nil, errors.New("Object description is too short")
+	return &ra, nil
+}
+
+// Function checks whether the RawAttraction's values are valid, it is shared by the API
+// and the import command. Returns ValidationErrors with every invalid value or nil.
+func (ra *RawAttraction) validate() error {
+
+	// see validation.go
+	var errs ValidationErrors
+
+	if len(ra.Description.Info) <= min_info_length {
+		errs.add("description.info", code_too_short, min_info_length)
 	}
 
-	if len(ra.Description.Name) <= 3 {
-		return nil, errors.New("Name is too short")
+	if len(ra.Description.Name) <= min_name_length {
+		errs.add("description.name", code_too_short, min_name_length)
 	}
 
 	// Name shouldn't be shorter than 3 characters and contain only lithuanian alphabet.
-	if len(ra.Location.City) <= 3 || !regex_lith.MatchString(ra.Location.City) {
-		return nil, errors.New("City is invalid")
+	if len(ra.Location.City) <= min_city_length || !regex_lith.MatchString(ra.Location.City) {
+		errs.add("location.city", code_invalid_city)
 	}
 
 	// Hours must match the patter defined in regex_hours.
-	for _, val := range []*string{&ra.Description.Hours.Wkd, &ra.Description.Hours.Std, &ra.Description.Hours.Snd} {
-		if !regex_hours.MatchString(*val) {
-			return nil, errors.New("Invalid open hours")
+	hours := map[string]string{"wkd": ra.Description.Hours.Wkd, "std": ra.Description.Hours.Std, "snd": ra.Description.Hours.Snd}
+	for _, key := range []string{"wkd", "std", "snd"} {
+		if !regex_hours.MatchString(hours[key]) {
+			errs.add("description.hours."+key, code_invalid_hours)
 		}
 	}
 
 	// see utils.go
 	if !sliceContains(&ra.Category, viable_categories) {
-		return nil, errors.New("Invalid category")
+		errs.add("category", code_invalid_category, viable_categories)
 	}
 
 	// Only coordinates in Lithuania are accepted.
-	if ra.Location.Coordinates.Latitude > 56.27 || ra.Location.Coordinates.Latitude < 53.53 ||
-		ra.Location.Coordinates.Longitude > 26.5 || ra.Location.Coordinates.Longitude < 20.56 {
-		return nil, errors.New("Location is outside of Lithuania")
+	if ra.Location.Coordinates.Latitude > max_latitude || ra.Location.Coordinates.Latitude < min_latitude {
+		errs.add("location.coordinates.latitude", code_outside_lithuania)
 	}
 
-	return &ra, nil
+	if ra.Location.Coordinates.Longitude > max_longitude || ra.Location.Coordinates.Longitude < min_longitude {
+		errs.add("location.coordinates.longitude", code_outside_lithuania)
+	}
+
+	// see gallery.go
+	ra.validateGallery(&errs)
+
+	// Nil ValidationErrors would be a non-nil error.
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
 }
 
 // Function takes in a name, removes lithuanian characters and spaces,
@@ -89,7 +167,67 @@ func (ra *RawAttraction) wrap() Attraction {
 	bytes, _ = json.Marshal(ra.Description)
 	description := string(bytes)
 
-	return Attraction{id, ra.Category, description, location, ra.Description.Name, createNullString(ra.Image.Url), createNullString(ra.Image.Copyright)}
+	a := Attraction{
+		id:          id,
+		category:    ra.Category,
+		description: description,
+		location:    location,
+		name:        ra.Description.Name,
+		url:         createNullString(ra.Image.Url),
+		copyright:   createNullString(ra.Image.Copyright),
+		images:      ra.gallery(),
+	}
+
+	// Target databases only know the url and attribution of the primary image.
+	if primary := a.primaryImage(); primary != nil && len(ra.Images) > 0 {
+		a.url = primary.url
+		a.copyright = createNullString(primary.attribution())
+	}
+
+	return a
+}
+
+// Function returns an AttractionView of the attraction that is
+// used to respond to read requests.
+func (a *Attraction) view() AttractionView {
+
+	v := AttractionView{
+		Id:          a.id,
+		Category:    a.category,
+		Description: json.RawMessage(a.description),
+		Location:    json.RawMessage(a.location),
+	}
+
+	v.Images = make([]ImageView, 0, len(a.images))
+
+	for _, img := range a.images {
+
+		iv := ImageView{
+			Position:    img.position,
+			Url:         img.url.String,
+			Caption:     img.caption.String,
+			Author:      img.author.String,
+			Licence:     img.licence,
+			Attribution: img.attribution(),
+			Primary:     img.primary,
+			Hash:        img.stored.String,
+			Blurhash:    img.blurhash.String,
+			Colour:      img.colour.String,
+		}
+
+		// Uploaded images are served from the image store.
+		if img.upload.Valid {
+			iv.Url = v1_prefix + "/images/" + img.upload.String
+		}
+
+		v.Images = append(v.Images, iv)
+
+		if img.primary {
+			v.Image = &v.Images[len(v.Images)-1]
+		}
+	}
+
+	return v
 }
 
 type Attraction struct {
@@ -100,30 +238,86 @@ type Attraction struct {
 	name        string
 	url         sql.NullString
 	copyright   sql.NullString
+	// Gallery ordered by position, see gallery.go
+	images []GalleryImage
+}
+
+type AttractionView struct {
+	Id          string          `json:"id"`
+	Category    string          `json:"category"`
+	Description json.RawMessage `json:"description"`
+	Location    json.RawMessage `json:"location"`
+	// Primary image of the gallery.
+	Image  *ImageView  `json:"image"`
+	Images []ImageView `json:"images"`
 }
 
+type ImageView struct {
+	Position    int    `json:"position"`
+	Url         string `json:"url"`
+	Caption     string `json:"caption,omitempty"`
+	Author      string `json:"author,omitempty"`
+	Licence     string `json:"licence"`
+	Attribution string `json:"attribution,omitempty"`
+	Primary     bool   `json:"primary"`
+	// Hash of the processed image in the image store.
+	Hash     string `json:"hash,omitempty"`
+	Blurhash string `json:"blurhash,omitempty"`
+	Colour   string `json:"colour,omitempty"`
+}
+
+// Body of /add, description and location are stored as json in the same form.
 type RawAttraction struct {
-	Category    string
+	Category    string `json:"category"`
 	Description struct {
-		Name  string
+		Name  string `json:"name"`
 		Hours struct {
-			Wkd string
-			Std string
-			Snd string
-		}
-		Info string
-	}
+			Wkd string `json:"wkd"`
+			Std string `json:"std"`
+			Snd string `json:"snd"`
+		} `json:"hours"`
+		Info string `json:"info"`
+	} `json:"description"`
 	Location struct {
-		City        string
-		Coordinates struct {
-			Latitude  float32
-			Longitude float32
-		}
-	}
+		City        string      `json:"city"`
+		Coordinates Coordinates `json:"coordinates"`
+	} `json:"location"`
 	Image struct {
-		Url       string
-		Copyright string
+		Url       string `json:"url"`
+		Copyright string `json:"copyright"`
+	} `json:"image"`
+	Images []RawImage `json:"images"`
+}
+
+type Coordinates struct {
+	Latitude  float32 `json:"latitude"`
+	Longitude float32 `json:"longitude"`
+}
+
+// Function returns attraction's coordinates parsed from
+// the stringified location json.
+func (a *Attraction) coordinates() Coordinates {
+	var location struct {
+		Coordinates Coordinates
+	}
+	json.Unmarshal([]byte(a.location), &location)
+	return location.Coordinates
+}
+
+// Function returns a checksum of the values that are merged into the target database and
+// of the gallery whose images merge processes, used to detect changed attractions. Values
+// written by processing the images (hashes, placeholders) are left out.
+func (a *Attraction) checksum() string {
+
+	values := []string{a.category, a.description, a.location, a.copyright.String, a.url.String}
+
+	for _, img := range a.images {
+		values = append(values, strconv.Itoa(img.position), img.url.String, img.upload.String, img.caption.String,
+			img.author.String, img.licence, strconv.FormatBool(img.primary))
 	}
+
+	sum := sha256.Sum256([]byte(strings.Join(values, "\x00")))
+	return hex.EncodeToString(sum[:8])
 }
 
 func (a Attraction) print() {
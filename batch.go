@@ -0,0 +1,223 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// Maximum number of attractions in a single batch request.
+const max_batch_size = 100
+
+// Maximum size of a batch request body in bytes.
+const max_batch_body_size = 4 << 20
+
+// Modes of POST /add/batch. Nothing is added in all-or-nothing mode if an item is
+// invalid, valid items are added one by one in best-effort mode.
+var batch_modes = []string{"all-or-nothing", "best-effort"}
+
+// Route handler to add several attractions to the database.
+// Function takes in the standart handler parameters http.ResponseWriter and a reference
+// to a http.Request with an array of attractions and responds with an error or an array
+// of BatchResults in the same order as the attractions. All-or-nothing batches with invalid
+// items are responded with ValidationErrors the same way as /add.
+func (s *Server) addAttractions(writer http.ResponseWriter, request *http.Request) {
+
+	mode := request.URL.Query().Get("mode")
+	if mode == "" {
+		mode = batch_modes[0]
+	}
+
+	if !sliceContains(&mode, batch_modes) {
+		// see messages.go
+		respondError(writer, request, apiError(http.StatusBadRequest, code_invalid_mode, batch_modes))
+		return
+	}
+
+	// Bodies larger than the limit fail to decode instead of filling up the memory.
+	request.Body = http.MaxBytesReader(writer, request.Body, max_batch_body_size)
+
+	var items []interface{}
+
+	// see utils.go
+	if err := validateJson(request, &items); err != nil {
+		respondError(writer, request, err)
+		return
+	}
+
+	if len(items) == 0 || len(items) > max_batch_size {
+		respondError(writer, request, apiError(http.StatusBadRequest, code_invalid_batch_size, max_batch_size))
+		return
+	}
+
+	lang := language(request)
+	writer.Header().Set("Content-Language", lang)
+
+	results, attractions, err := s.validateBatch(items, lang)
+	if err != nil {
+		respondError(writer, request, err)
+		return
+	}
+
+	if mode == "best-effort" {
+
+		for ind := range attractions {
+
+			if results[ind].Error != "" {
+				continue
+			}
+
+			// Committing each attraction in its own transaction, see store.go
+			var exists *ExistsError
+			if err := s.store.add(&attractions[ind]); errors.As(err, &exists) {
+				results[ind].fail(lang, code_attraction_exists)
+			} else if err != nil {
+				log.Printf("%s %s: %s", request.Method, request.URL.Path, err.Error())
+				results[ind].fail(lang, code_internal_error)
+			}
+		}
+
+		respond(writer, http.StatusOK, results)
+		return
+	}
+
+	// Any invalid item rejects the whole batch, see validation.go
+	if !batchValid(results) {
+		respondError(writer, request, batchErrors(results))
+		return
+	}
+
+	// Committing the attractions in a single transaction, attractions added since they were
+	// validated are rejected by the store, see store.go
+	err = s.store.addAll(attractions)
+
+	var exists *ExistsError
+	if errors.As(err, &exists) {
+		for ind := range attractions {
+			if attractions[ind].id == exists.id {
+				results[ind].fail(lang, code_attraction_exists)
+			}
+		}
+		respondError(writer, request, batchErrors(results))
+		return
+	}
+
+	if err != nil {
+		respondError(writer, request, err)
+		return
+	}
+
+	respond(writer, http.StatusOK, results)
+}
+
+// Function takes in a slice of decoded attractions and a language of the messages and validates
+// each attraction the same way /add does. Attractions that already exist or repeat an earlier item
+// are rejected, so every invalid item is reported at once. Stores check again whether attractions
+// exist when they add them. Returns a slice of BatchResults, a slice of Attractions with a zero
+// value for invalid items, and an error if the store couldn't be read.
+func (s *Server) validateBatch(items []interface{}, lang string) ([]BatchResult, []Attraction, error) {
+
+	results := make([]BatchResult, len(items))
+	attractions := make([]Attraction, len(items))
+	// Ids of valid items to their indexes.
+	seen := map[string]int{}
+
+	for ind, item := range items {
+
+		results[ind] = BatchResult{Index: ind}
+
+		// see attraction.go
+		ra, err := parseAttraction(item)
+		if err != nil {
+			// see validation.go
+			var invalid ValidationErrors
+			errors.As(err, &invalid)
+			results[ind].Errors = invalid.localise(lang)
+			results[ind].Error = results[ind].Errors.Error()
+			continue
+		}
+
+		attraction := ra.wrap()
+		results[ind].Id = attraction.id
+
+		if first, ok := seen[attraction.id]; ok {
+			results[ind].fail(lang, code_same_as_item, first)
+			continue
+		}
+
+		existing, err := s.store.get(attraction.id)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if existing != nil {
+			results[ind].fail(lang, code_attraction_exists)
+			continue
+		}
+
+		seen[attraction.id] = ind
+		attractions[ind] = attraction
+	}
+
+	return results, attractions, nil
+}
+
+// Function takes in a slice of BatchResults and returns errors of the invalid items as
+// ValidationErrors, paths are prefixed by the item's index, e.g. [1].description.name
+func batchErrors(results []BatchResult) ValidationErrors {
+
+	var errs ValidationErrors
+
+	for _, r := range results {
+
+		prefix := fmt.Sprintf("[%d]", r.Index)
+
+		for _, e := range r.Errors {
+			if e.Path != "" {
+				e.Path = prefix + "." + e.Path
+			} else {
+				e.Path = prefix
+			}
+			errs = append(errs, e)
+		}
+
+		// Items rejected with a single code, e.g. attraction_exists.
+		if r.Error != "" && len(r.Errors) == 0 {
+			errs.add(prefix, r.Code, r.args...)
+		}
+	}
+
+	return errs
+}
+
+// Function takes in a slice of BatchResults and returns whether every item is valid.
+func batchValid(results []BatchResult) bool {
+	for _, r := range results {
+		if r.Error != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// Function takes in a language, an error code and values formatted
+// into its message and marks the result as failed.
+func (r *BatchResult) fail(lang, code string, args ...interface{}) {
+	r.Code, r.Error, r.args = code, message(lang, code, args...), args
+}
+
+// Result of a single attraction of a batch request.
+type BatchResult struct {
+	// Position of the attraction in the request.
+	Index int `json:"index"`
+	// Id of the attraction, missing if it's invalid.
+	Id    string `json:"id,omitempty"`
+	Error string `json:"error,omitempty"`
+	// Code of the error, see messages.go
+	Code string `json:"code,omitempty"`
+	// Invalid values of the attraction, see validation.go
+	Errors ValidationErrors `json:"errors,omitempty"`
+	// Values formatted into the message of the code.
+	args []interface{}
+}
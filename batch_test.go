@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Function takes in a name and returns a valid attraction body with it.
+func testAttractionJson(name string) string {
+	return fmt.Sprintf(`{"category":"nature","description":{"name":%q,"hours":{"wkd":"08:00-20:00","std":"08:00-20:00",
+		"snd":"08:00-20:00"},"info":"Labai graži vieta prie upės su takais"},
+		"location":{"city":"Vilnius","coordinates":{"latitude":54.7,"longitude":25.3}}}`, name)
+}
+
+// Function takes in a server, a query and a body and posts the body to /add/batch.
+// Returns the recorded response.
+func postBatchRequest(s *Server, query, body string) *httptest.ResponseRecorder {
+
+	recorder := httptest.NewRecorder()
+	s.router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, v1_prefix+"/add/batch"+query, strings.NewReader(body)))
+
+	return recorder
+}
+
+// Function takes in a test and a response and returns its ValidationErrors by path.
+func responseErrors(t *testing.T, recorder *httptest.ResponseRecorder) map[string]FieldError {
+
+	var response struct{ Errors ValidationErrors }
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+
+	errs := map[string]FieldError{}
+	for _, e := range response.Errors {
+		errs[e.Path] = e
+	}
+	return errs
+}
+
+func TestBatchRejectsInvalidItems(t *testing.T) {
+
+	s := testServer()
+	if err := s.store.add(&Attraction{id: "senas", name: "Senas"}); err != nil {
+		t.Fatal(err)
+	}
+
+	body := "[" + strings.Join([]string{
+		testAttractionJson("Gražuolis"),
+		`{"category":"nature"}`,
+		testAttractionJson("Gražuolis"),
+		testAttractionJson("Senas"),
+	}, ",") + "]"
+
+	recorder := postBatchRequest(s, "", body)
+	if recorder.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("batch responded with %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	errs := responseErrors(t, recorder)
+	for path, code := range map[string]string{
+		"[1].description": code_required,
+		"[2]":             code_same_as_item,
+		"[3]":             code_attraction_exists,
+	} {
+		if errs[path].Code != code {
+			t.Errorf("%s has code %q, want %q", path, errs[path].Code, code)
+		}
+	}
+	if _, ok := errs["[0]"]; ok {
+		t.Error("valid item has an error")
+	}
+	if errs["[2]"].Message != "Same attraction as item 0" {
+		t.Errorf("message is %q", errs["[2]"].Message)
+	}
+
+	if list, _ := s.store.list(); len(list) != 1 {
+		t.Fatalf("rejected batch added %d attractions", len(list)-1)
+	}
+}
+
+// AttractionStore that doesn't find any attraction, as if they were added after the batch was validated.
+type racingStore struct {
+	AttractionStore
+}
+
+func (r racingStore) get(id string) (*Attraction, error) {
+	return nil, nil
+}
+
+// Attractions added after the batch was validated are found by the store's transaction.
+func TestBatchChecksExistingInTransaction(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store AttractionStore) {
+
+		if err := store.add(&Attraction{id: "senas", name: "Senas"}); err != nil {
+			t.Fatal(err)
+		}
+
+		err := store.addAll([]Attraction{{id: "naujas", name: "Naujas"}, {id: "senas", name: "Senas"}})
+		if exists, ok := err.(*ExistsError); !ok || exists.id != "senas" {
+			t.Fatalf("addAll returned %v, want an ExistsError of senas", err)
+		}
+
+		s := testServer()
+		s.store = racingStore{store}
+
+		body := "[" + testAttractionJson("Gražuolis") + "," + testAttractionJson("Senas") + "]"
+		recorder := postBatchRequest(s, "", body)
+		if errs := responseErrors(t, recorder); recorder.Code != http.StatusUnprocessableEntity || errs["[1]"].Code != code_attraction_exists {
+			t.Fatalf("batch responded with %d: %s", recorder.Code, recorder.Body.String())
+		}
+
+		if list, _ := store.list(); len(list) != 1 {
+			t.Fatalf("rejected batch added %d attractions", len(list)-1)
+		}
+	})
+}
+
+func TestBatchBestEffort(t *testing.T) {
+
+	s := testServer()
+	if err := s.store.add(&Attraction{id: "senas", name: "Senas"}); err != nil {
+		t.Fatal(err)
+	}
+
+	body := "[" + testAttractionJson("Gražuolis") + "," + testAttractionJson("Senas") + "]"
+	recorder := postBatchRequest(s, "?mode=best-effort", body)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("batch responded with %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	var results []BatchResult
+	if err := json.Unmarshal(recorder.Body.Bytes(), &results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].Error != "" || results[1].Code != code_attraction_exists {
+		t.Fatalf("results are %+v", results)
+	}
+}
+
+func TestBatchBodyLimit(t *testing.T) {
+
+	body := "[" + testAttractionJson(strings.Repeat("a", max_batch_body_size)) + "]"
+
+	recorder := postBatchRequest(testServer(), "", body)
+	if recorder.Code != http.StatusRequestEntityTooLarge || !strings.Contains(recorder.Body.String(), code_json_too_large) {
+		t.Fatalf("batch responded with %d: %s", recorder.Code, recorder.Body.String())
+	}
+}
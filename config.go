@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Path to the optional configuration file. Defaults are used if it doesn't exist.
+const config_path = "./assets/config.json"
+
+type Config struct {
+	Quality QualityConfig
+	Send    SendConfig
+	Upload  UploadConfig
+	Store   StoreConfig
+	Import  ImportConfig
+	// Attribution overlay options keyed by image licence,
+	// images of licences that are not present are left as is.
+	Watermark map[string]WatermarkConfig
+}
+
+// Rules used to reject images that would look bad after processing.
+type QualityConfig struct {
+	// Minimum dimensions of the source image in pixels.
+	MinWidth  int
+	MinHeight int
+	// Maximum factor by which the source image may be upscaled.
+	MaxUpscale float64
+	// Minimum variance of the Laplacian of the processed image.
+	MinSharpness float64
+}
+
+// Options of posting processed images to an url.
+type SendConfig struct {
+	// Number of images posted in a single request.
+	BatchSize int
+	// Request body format, one of send_formats.
+	Format string
+	// Number of times a failed request is repeated.
+	Retries int
+	// Request timeout in seconds.
+	Timeout int
+	// Secret shared with the receiver used to sign requests, see signature/
+	Secret string
+}
+
+// Options of images uploaded through the API.
+type UploadConfig struct {
+	// Maximum size of the request body in bytes.
+	MaxSize int64
+}
+
+// Options of the local image store, see imagestore.go
+type StoreConfig struct {
+	// Directory images and their renditions are stored in.
+	Root string
+	// Widths and heights renditions can be requested in, other sizes are refused
+	// so clients can't fill the disk with renditions of every size.
+	Renditions []int
+}
+
+// Options of spreadsheets read by the import command, see import.go
+type ImportConfig struct {
+	// Spreadsheet column headers keyed by the attraction fields in import_fields.
+	// Fields that are not present keep their default header.
+	Columns map[string]string
+	// Sheet of xlsx workbooks rows are read from, the first sheet if empty.
+	Sheet string
+}
+
+// Options of the attribution rendered over processed images, see watermark.go
+type WatermarkConfig struct {
+	// Corner the attribution is placed in, one of watermark_corners.
+	Corner string
+	// Opacity of the text between 0 and 1.
+	Opacity float64
+	// Font size in pixels.
+	Size float64
+}
+
+// Configuration used throughout the program.
+var config = defaultConfig()
+
+// Function returns a Config with default values.
+func defaultConfig() Config {
+	return Config{
+		Quality: QualityConfig{
+			MinWidth:     800,
+			MinHeight:    533,
+			MaxUpscale:   1.5,
+			MinSharpness: 50,
+		},
+		Send: SendConfig{
+			BatchSize: 20,
+			Format:    "ndjson",
+			Retries:   2,
+			Timeout:   60,
+		},
+		Upload: UploadConfig{
+			MaxSize: 10 << 20,
+		},
+		Store: StoreConfig{
+			Root:       "./assets/images",
+			Renditions: []int{160, 320, 480, 640, 800, 1200},
+		},
+		// Headers default to the field names, the same as the csv export.
+		Import: ImportConfig{
+			Columns: importColumns(),
+		},
+		// CC BY licences require attribution next to the image.
+		Watermark: map[string]WatermarkConfig{
+			"cc-by":    {Corner: "bottom-right", Opacity: 0.8, Size: 18},
+			"cc-by-sa": {Corner: "bottom-right", Opacity: 0.8, Size: 18},
+		},
+	}
+}
+
+// Function takes in a path to a json configuration file and overrides
+// the default configuration with its contents. Missing file is not an error.
+func loadConfig(path string) error {
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	// Misspelled options should not be silently ignored.
+	decoder.DisallowUnknownFields()
+
+	loaded := defaultConfig()
+	// Watermark map is replaced rather than merged so default licences can be disabled.
+	loaded.Watermark = nil
+
+	if err := decoder.Decode(&loaded); err != nil {
+		return err
+	}
+
+	if loaded.Watermark == nil {
+		loaded.Watermark = defaultConfig().Watermark
+	}
+
+	if err := loaded.validate(); err != nil {
+		return err
+	}
+
+	config = loaded
+
+	return nil
+}
+
+// Function checks whether configured values are usable and
+// returns an error if they are not.
+func (c *Config) validate() error {
+
+	if c.Send.BatchSize < 1 {
+		return errors.New("send.batchSize must be at least 1")
+	}
+
+	if c.Send.Retries < 0 {
+		return errors.New("send.retries must not be negative")
+	}
+
+	if c.Send.Timeout < 1 {
+		return errors.New("send.timeout must be at least 1 second")
+	}
+
+	if !sliceContains(&c.Send.Format, send_formats) {
+		return fmt.Errorf("send.format must be one of %v", send_formats)
+	}
+
+	for _, size := range c.Store.Renditions {
+		if size < 1 || size > max_rendition_size {
+			return fmt.Errorf("store.renditions must be between 1 and %d", max_rendition_size)
+		}
+	}
+
+	for field := range c.Import.Columns {
+		if !sliceContains(&field, import_fields) {
+			return fmt.Errorf("import.columns keys must be one of %v", import_fields)
+		}
+	}
+
+	for licence, rule := range c.Watermark {
+		if !sliceContains(&licence, viable_licences) {
+			return fmt.Errorf("watermark licence must be one of %v", viable_licences)
+		}
+		if !sliceContains(&rule.Corner, watermark_corners) {
+			return fmt.Errorf("watermark.%s.corner must be one of %v", licence, watermark_corners)
+		}
+		if rule.Opacity <= 0 || rule.Opacity > 1 || rule.Size <= 0 {
+			return fmt.Errorf("watermark.%s requires opacity between 0 and 1 and a positive size", licence)
+		}
+	}
+
+	return nil
+}
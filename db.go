@@ -6,10 +6,46 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
+// Path to the cache database.
+const cache_path = "./assets/cache.db"
+
+// Query that selects attractions from the cache.
+const attractions_query = "SELECT id, category, description, location, url, copyright FROM destinations"
+
+// AttractionStore that keeps attractions in the cache database, see store.go
+type SQLiteStore struct {
+	connection *sql.DB
+}
+
+// Function takes in a path to the cache database, opens it and migrates it to
+// the latest schema version. Returns a reference to the SQLiteStore and an error
+// if it occurs.
+func openSQLiteStore(path string) (*SQLiteStore, error) {
+
+	var connection *sql.DB
+
+	if err := getConnection(path, &connection); err != nil {
+		return nil, err
+	}
+
+	// see migrate.go
+	if _, err := migrateCache(connection); err != nil {
+		connection.Close()
+		return nil, err
+	}
+
+	return &SQLiteStore{connection}, nil
+}
+
+func (s *SQLiteStore) close() error {
+	return s.connection.Close()
+}
+
 //Function takes in an interface that contains an Exec method (sql.Tx or sql.DB)
 // and an unpacked slice of Title structs. Titles are committed to the database
 // and used to check whether it exists. An error returned if it occurs.
@@ -17,6 +53,10 @@ func commitTitles(connection interface {
 	Exec(string, ...interface{}) (sql.Result, error)
 }, titles ...Title) error {
 
+	if len(titles) == 0 {
+		return nil
+	}
+
 	values, args := make([]string, 0, len(titles)), make([]interface{}, 0, len(titles)*2)
 
 	for _, title := range titles {
@@ -34,7 +74,14 @@ func commitTitles(connection interface {
 
 // Function takes in a reference to an Attraction and commits it to the cache.
 // An error is returned if it occurs.
-func (s *Server) commitAttraction(a *Attraction) error {
+func (s *SQLiteStore) add(a *Attraction) error {
+	return s.addAll([]Attraction{*a})
+}
+
+// Function takes in a slice of Attractions and commits them to the cache in a single
+// transaction, none are committed if one fails. An error is returned if it occurs,
+// an ExistsError if an attraction already exists or is repeated.
+func (s *SQLiteStore) addAll(attractions []Attraction) error {
 
 	// Starting a transaction.
 	tx, err := s.connection.Begin()
@@ -42,28 +89,61 @@ func (s *Server) commitAttraction(a *Attraction) error {
 		return err
 	}
 
+	for ind := range attractions {
+
+		// Checked in the transaction so attractions added meanwhile are seen, repeated ones were inserted by it.
+		var exists bool
+		if err := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM destinations WHERE id = ?)", attractions[ind].id).Scan(&exists); err != nil || exists {
+			tx.Rollback()
+			if err == nil {
+				err = &ExistsError{attractions[ind].id}
+			}
+			return err
+		}
+
+		if err := insertAttraction(tx, &attractions[ind]); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Function takes in an interface that contains an Exec method (sql.Tx or sql.DB) and a
+// reference to an Attraction and inserts the attraction with its title and gallery.
+// An error is returned if it occurs.
+func insertAttraction(connection interface {
+	Exec(string, ...interface{}) (sql.Result, error)
+}, a *Attraction) error {
+
 	// Adding the attraction to the cache database.
-	_, err = tx.Exec("INSERT INTO destinations(id, category, description, location, url, copyright) VALUES(?,?,?,?,?,?)",
+	_, err := connection.Exec("INSERT INTO destinations(id, category, description, location, url, copyright) VALUES(?,?,?,?,?,?)",
 		&a.id, &a.category, &a.description, &a.location, &a.url, &a.copyright)
 	if err != nil {
-		tx.Rollback()
 		return err
 	}
 
 	// Committing attraction's id and name to the cache.
-	if err := commitTitles(tx, Title{a.id, a.name}); err != nil {
-		tx.Rollback()
+	if err := commitTitles(connection, Title{a.id, a.name}); err != nil {
 		return err
 	}
 
-	return tx.Commit()
+	// Committing attraction's gallery, see gallery.go
+	return commitImages(connection, a.id, a.images...)
+}
+
+// Function takes in an unpacked slice of Title structs and commits
+// them to the cache. An error is returned if it occurs.
+func (s *SQLiteStore) addTitles(titles ...Title) error {
+	return commitTitles(s.connection, titles...)
 }
 
 // Function reads attractions' ids and names from the cache and returns a
 // reference to a TitleValues struct and an error if it occurs.
-func (s *Server) readTitles() (*TitleValues, error) {
+func (s *SQLiteStore) titles() (*TitleValues, error) {
 
-	rows, err := s.connection.Query("SELECT * FROM  titles")
+	rows, err := s.connection.Query("SELECT compare, display FROM titles")
 
 	if err != nil {
 		return nil, errors.New("Failed to read cache")
@@ -88,15 +168,11 @@ func (s *Server) readTitles() (*TitleValues, error) {
 	return getTitleFields(titles), nil
 }
 
-// Function reads attractions from the cache database and returns
-// a slice with Attraction structs and an error if it occurs.
-func readCache() ([]Attraction, error) {
-
-	var connection *sql.DB
-
-	getConnection("./assets/cache.db", &connection)
+// Function reads all attractions with their galleries from the cache
+// and returns a slice of Attraction structs and an error if it occurs.
+func (s *SQLiteStore) list() ([]Attraction, error) {
 
-	rows, err := connection.Query("SELECT * FROM  destinations")
+	rows, err := s.connection.Query(attractions_query + " ORDER BY id")
 
 	if err != nil {
 		return nil, errors.New("Failed to read cache")
@@ -104,19 +180,134 @@ func readCache() ([]Attraction, error) {
 
 	defer rows.Close()
 
-	var attractions []Attraction
-
-	// Temporary Attraction struct to read the values to.
-	tmp_att := Attraction{}
+	attractions := make([]Attraction, 0)
 
 	for rows.Next() {
 
-		err := rows.Scan(&tmp_att.id, &tmp_att.category, &tmp_att.location, &tmp_att.description, &tmp_att.copyright, &tmp_att.url)
+		attraction, err := scanAttraction(rows)
 		if err != nil {
-			return nil, errors.New("Failed to read row")
+			return nil, err
+		}
+
+		attractions = append(attractions, *attraction)
+	}
+
+	// see gallery.go
+	images, err := readImages(s.connection, "")
+	if err != nil {
+		return nil, err
+	}
+
+	for ind := range attractions {
+		attractions[ind].images = images[attractions[ind].id]
+	}
+
+	return attractions, nil
+}
+
+// Function takes in an attraction's id and reads it with its gallery
+// from the cache. Returns a reference to the Attraction, or nil if it doesn't
+// exist, and an error if it occurs.
+func (s *SQLiteStore) get(id string) (*Attraction, error) {
+
+	attraction, err := scanAttraction(s.connection.QueryRow(attractions_query+" WHERE id = ?", id))
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// see gallery.go
+	images, err := readImages(s.connection, id)
+	if err != nil {
+		return nil, err
+	}
+
+	attraction.images = images[id]
+
+	return attraction, nil
+}
+
+// Function takes in a row (sql.Row or sql.Rows) selected with attractions_query
+// and returns a reference to the scanned Attraction and an error if it occurs.
+func scanAttraction(row interface {
+	Scan(...interface{}) error
+}) (*Attraction, error) {
+
+	var a Attraction
+
+	err := row.Scan(&a.id, &a.category, &a.description, &a.location, &a.url, &a.copyright)
+
+	if err == sql.ErrNoRows {
+		return nil, err
+	}
+	if err != nil {
+		return nil, errors.New("Failed to read row")
+	}
+
+	return &a, nil
+}
+
+// Function takes in a reference to an Attraction and updates its values in
+// the cache, its gallery is left as is. An error is returned if it occurs.
+func (s *SQLiteStore) update(a *Attraction) error {
+
+	result, err := s.connection.Exec("UPDATE destinations SET category = ?, description = ?, location = ?, url = ?, copyright = ? WHERE id = ?",
+		a.category, a.description, a.location, a.url, a.copyright, a.id)
+	if err != nil {
+		return err
+	}
+
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return errNotFound
+	}
+
+	return nil
+}
+
+// Function takes in an attraction's id and deletes it with its title, gallery
+// and merge records from the cache. An error is returned if it occurs.
+func (s *SQLiteStore) delete(id string) error {
+
+	tx, err := s.connection.Begin()
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.Exec("DELETE FROM destinations WHERE id = ?", id)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		tx.Rollback()
+		return errNotFound
+	}
+
+	for _, stmt := range []string{
+		"DELETE FROM titles WHERE compare = ?",
+		"DELETE FROM images WHERE attraction_id = ?",
+		"DELETE FROM merged WHERE id = ?",
+	} {
+		if _, err := tx.Exec(stmt, id); err != nil {
+			tx.Rollback()
+			return err
 		}
+	}
+
+	return tx.Commit()
+}
+
+// Function takes in an AttractionStore and reads all of its attractions.
+// Returns a slice with Attraction structs and an error if it occurs.
+func readCache(store AttractionStore) ([]Attraction, error) {
 
-		attractions = append(attractions, tmp_att)
+	attractions, err := store.list()
+	if err != nil {
+		return nil, err
 	}
 
 	if len(attractions) == 0 {
@@ -143,53 +334,190 @@ func getConnection(path string, connection_ref **sql.DB) error {
 	return err
 }
 
-// Function takes in an url to the databse and a slice of Attraction structs.
-// Attractions are committed to the database and an error is returned if
-// it occurs.
-func commitAttractionsToDB(url string, attractions []Attraction) error {
+// Function takes in an url to the databse, a SQLite path or a PostgreSQL DSN, a slice of
+// Attraction structs and a function that records the rows before they're committed. Attractions
+// are inserted into the database, or update existing rows with the same id, in a single transaction
+// that is rolled back if recording fails. Returns a MergeResult and an error if it occurs, the
+// result is also returned if the rows were recorded but the transaction failed to commit.
+func commitAttractionsToDB(url string, attractions []Attraction, record func(*MergeResult) error) (*MergeResult, error) {
 
-	var connection *sql.DB
-	// Connecting to the database
+	// Connecting to the database, see target.go
+	target, err := openTarget(url, false)
+	if err != nil {
+		return nil, err
+	}
 
-	if err := getConnection(url, &connection); err != nil {
-		return err
+	defer target.close()
+
+	// Refusing before anything is written.
+	if err := target.verify(); err != nil {
+		return nil, err
 	}
 
-	values, args := make([]string, 0, len(attractions)), make([]interface{}, 0, len(attractions)*5)
+	tx, err := target.connection.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &MergeResult{}
 
 	for _, attr := range attractions {
-		// Adding value operators o an array instead of appending a string in
-		// order to not leave trailing commas.
-		values = append(values, "(?, ?, ?, ?, ?)")
-		args = append(args, attr.id, attr.category, attr.description, attr.location, attr.copyright)
+
+		existing, err := target.readAttraction(tx, attr.id)
+
+		switch {
+
+		case err != nil:
+			// Reading failed, handled below.
+
+		case existing == nil:
+			err = target.write(tx, attr.id, targetRow(&attr), false)
+			result.inserted = append(result.inserted, attr.id)
+			result.rows = append(result.rows, MergeRow{attr.id, nil, targetRow(&attr)})
+
+		case sameValues(existing, &attr):
+			result.skipped = append(result.skipped, attr.id)
+
+		default:
+			err = target.write(tx, attr.id, targetRow(&attr), true)
+			result.updated = append(result.updated, attr.id)
+			before := targetRow(existing)
+			result.rows = append(result.rows, MergeRow{attr.id, &before, targetRow(&attr)})
+		}
+
+		if err != nil {
+			tx.Rollback()
+			return nil, fmt.Errorf("%s: %s", attr.id, err.Error())
+		}
 	}
 
-	stmt := fmt.Sprintf("INSERT INTO destinations (id, category, description, location, copyright) VALUES %s", strings.Join(values, ","))
-	_, err := connection.Exec(stmt, args...)
-	
-	// Clearing cache
-	connection.Exec("DELETE FROM destinations")
+	if err := record(result); err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("failed to record the merge run: %s", err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+// Function returns a map of merged attractions' ids to their checksums at
+// the time of merging and an error if it occurs.
+func (s *SQLiteStore) merged() (map[string]string, error) {
+
+	rows, err := s.connection.Query("SELECT id, checksum FROM merged")
+
+	if err != nil {
+		return nil, errors.New("Failed to read cache")
+	}
+
+	defer rows.Close()
+
+	var (
+		merged = map[string]string{}
+		// Temporary values to read the row to.
+		tmp_id, tmp_sum string
+	)
+
+	for rows.Next() {
+
+		if err := rows.Scan(&tmp_id, &tmp_sum); err != nil {
+			return nil, errors.New("Failed to read row")
+		}
+
+		merged[tmp_id] = tmp_sum
+	}
+
+	return merged, nil
+}
+
+// Function takes in an AttractionStore and reads attractions that were added or
+// changed since they were last merged. Returns a slice of Attraction structs and
+// an error if it occurs.
+func readPending(store AttractionStore) ([]Attraction, error) {
+
+	attractions, err := store.list()
+	if err != nil {
+		return nil, err
+	}
+
+	merged, err := store.merged()
+	if err != nil {
+		return nil, err
+	}
+
+	pending := make([]Attraction, 0, len(attractions))
+	for _, attr := range attractions {
+		if sum, ok := merged[attr.id]; !ok || sum != attr.checksum() {
+			pending = append(pending, attr)
+		}
+	}
+
+	if len(pending) == 0 {
+		return nil, errors.New("Nothing to merge, every attraction is up to date")
+	}
+
+	return pending, nil
+}
+
+// Function takes in a slice of merged attractions and a merge batch id and marks
+// attractions as merged in the cache instead of deleting them. An error is returned
+// if it occurs.
+func (s *SQLiteStore) markMerged(attractions []Attraction, batch string) error {
+
+	if len(attractions) == 0 {
+		return nil
+	}
+
+	values, args := make([]string, 0, len(attractions)), make([]interface{}, 0, len(attractions)*4)
+	now := time.Now().UTC()
+
+	for _, attr := range attractions {
+		values = append(values, "(?, ?, ?, ?)")
+		args = append(args, attr.id, batch, now, attr.checksum())
+	}
+
+	stmt := fmt.Sprintf("INSERT OR REPLACE INTO merged (id, batch, merged_at, checksum) VALUES %s", strings.Join(values, ","))
+	_, err := s.connection.Exec(stmt, args...)
 
 	return err
 }
 
-// Function takes in a path to a database with initial data in order to
-// store ids of attractions that already exist. Returns a string with
+// Ids of attractions written to the target database by commitAttractionsToDB.
+type MergeResult struct {
+	inserted []string
+	updated  []string
+	// Attractions that are identical in the target database.
+	skipped []string
+	// Rows written to the target, see history.go
+	rows []MergeRow
+}
+
+func (r *MergeResult) String() string {
+	return fmt.Sprintf("%d inserted, %d updated, %d skipped", len(r.inserted), len(r.updated), len(r.skipped))
+}
+
+// Function takes in a path to a database with initial data and an AttractionStore
+// in order to store ids of attractions that already exist. Returns a string with
 // the execution result.
-func initializeTitles(path string) string {
-	var t_con, c_con *sql.DB
+func initializeTitles(path string, store AttractionStore) string {
 
-	// Connecting to the initial database
-	if err := getConnection(path, &t_con); err != nil {
+	// Connecting to the initial database, see target.go
+	target, err := openTarget(path, true)
+	if err != nil {
 		return "Failed to open target database"
 	}
 
-	//Connecting to the cache
-	if err := getConnection("./assets/cache.db", &c_con); err != nil {
-		return "Failed to open cache"
+	defer target.close()
+
+	if err := target.verify(); err != nil {
+		return fmt.Sprintf("Failed to initialize: %s", err.Error())
 	}
 
-	target_rows, err := t_con.Query("SELECT description FROM  destinations")
+	// JSONB descriptions of PostgreSQL targets are read as text.
+	target_rows, err := target.connection.Query("SELECT CAST(description AS TEXT) FROM destinations")
 
 	if err != nil {
 		return "Failed to read target database"
@@ -219,11 +547,49 @@ func initializeTitles(path string) string {
 	}
 
 	// Committing ids and names to the cache
-	commitTitles(c_con, titles...)
+	if err := store.addTitles(titles...); err != nil {
+		return "Failed to commit titles"
+	}
 
 	return "Done"
 }
 
+// Function returns a slice of hashes of all processed
+// images in the cache and an error if it occurs.
+func (s *SQLiteStore) hashes() ([]ImageHash, error) {
+
+	rows, err := s.connection.Query("SELECT attraction_id, position, phash FROM images WHERE phash IS NOT NULL")
+
+	if err != nil {
+		return nil, errors.New("Failed to read cache")
+	}
+
+	defer rows.Close()
+
+	var (
+		hashes []ImageHash
+		// Temporary values to read the row to.
+		tmp_id, tmp_hash string
+		tmp_pos          int
+	)
+
+	for rows.Next() {
+
+		if err := rows.Scan(&tmp_id, &tmp_pos, &tmp_hash); err != nil {
+			return nil, errors.New("Failed to read row")
+		}
+
+		hash, err := parseHash(tmp_hash)
+		if err != nil {
+			return nil, errors.New("Failed to read row")
+		}
+
+		hashes = append(hashes, ImageHash{tmp_id, tmp_pos, hash})
+	}
+
+	return hashes, nil
+}
+
 type Title struct {
 	compare string
 	display string
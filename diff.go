@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Function takes in arguments of merge --dry-run, a path to the target database and an
+// optional --json flag, and an AttractionStore. Attractions that merge would write are compared
+// with the target without writing to either database or downloading images. Returns a report
+// as text or json, errors are returned as {"error": ...} in json mode.
+func dryRun(args []string, store AttractionStore) string {
+
+	as_json := len(args) > 1 && args[1] == "--json"
+
+	report, err := compareTarget(args, store)
+
+	if !as_json {
+		if err != nil {
+			return err.Error()
+		}
+		return report.String()
+	}
+
+	if err != nil {
+		return jsonError(err)
+	}
+
+	bytes, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return jsonError(err)
+	}
+
+	return string(bytes)
+}
+
+// Function takes in an error and returns it as a json object, {"error": ...}
+func jsonError(err error) string {
+	// Maps of strings always marshal.
+	bytes, _ := json.Marshal(map[string]string{"error": err.Error()})
+	return string(bytes)
+}
+
+// Function takes in arguments of merge --dry-run and an AttractionStore and compares pending
+// attractions with the target. Returns a reference to the DryRunReport and an error if it occurs.
+func compareTarget(args []string, store AttractionStore) (*DryRunReport, error) {
+
+	if len(args) < 1 {
+		return nil, errors.New("No destination db provided")
+	}
+
+	// see target.go
+	target, err := openTarget(args[0], true)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open target database: %s", err.Error())
+	}
+
+	defer target.close()
+
+	if err := target.verify(); err != nil {
+		return nil, err
+	}
+
+	report := DryRunReport{
+		Target:    redactTarget(args[0]),
+		Inserted:  make([]string, 0),
+		Updated:   make([]AttractionDiff, 0),
+		Unchanged: make([]string, 0),
+	}
+
+	// see db.go
+	attractions, err := readPending(store)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, attr := range attractions {
+
+		existing, err := target.readAttraction(target.connection, attr.id)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read target database: %s", err.Error())
+		}
+
+		switch {
+		case existing == nil:
+			report.Inserted = append(report.Inserted, attr.id)
+		case sameValues(existing, &attr):
+			report.Unchanged = append(report.Unchanged, attr.id)
+		default:
+			report.Updated = append(report.Updated, AttractionDiff{attr.id, diffAttractions(existing, &attr)})
+		}
+	}
+
+	return &report, nil
+}
+
+// Function takes in references to the target's and cache's versions of an
+// attraction and returns a slice of changed fields. Description and location
+// are compared field by field.
+func diffAttractions(old, new *Attraction) []FieldChange {
+
+	changes := make([]FieldChange, 0)
+
+	if old.category != new.category {
+		changes = append(changes, FieldChange{"category", old.category, new.category})
+	}
+
+	// see target.go
+	if !sameCopyright(old, new) {
+		changes = append(changes, FieldChange{"copyright", old.copyright.String, new.copyright.String})
+	}
+
+	for _, field := range []struct {
+		name     string
+		old, new string
+	}{{"description", old.description, new.description}, {"location", old.location, new.location}} {
+
+		var old_value, new_value interface{}
+		json.Unmarshal([]byte(field.old), &old_value)
+		json.Unmarshal([]byte(field.new), &new_value)
+
+		diffJson(field.name, old_value, new_value, &changes)
+	}
+
+	return changes
+}
+
+// Function takes in a path of a json value, its old and new values and a reference
+// to a slice of FieldChanges. Objects are compared key by key, other values are
+// compared as a whole and added to the slice if they differ.
+func diffJson(path string, old, new interface{}, changes *[]FieldChange) {
+
+	old_obj, old_ok := old.(map[string]interface{})
+	new_obj, new_ok := new.(map[string]interface{})
+
+	if !old_ok || !new_ok {
+		old_bytes, _ := json.Marshal(old)
+		new_bytes, _ := json.Marshal(new)
+		if string(old_bytes) != string(new_bytes) {
+			*changes = append(*changes, FieldChange{path, old, new})
+		}
+		return
+	}
+
+	// Keys of both objects in a stable order.
+	keys := make([]string, 0, len(old_obj)+len(new_obj))
+	for key := range old_obj {
+		keys = append(keys, key)
+	}
+	for key := range new_obj {
+		if _, ok := old_obj[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		diffJson(path+"."+key, old_obj[key], new_obj[key], changes)
+	}
+}
+
+func (r *DryRunReport) String() string {
+
+	var builder strings.Builder
+
+	fmt.Fprintf(&builder, "Dry run against %s: %d to insert, %d to update, %d unchanged\n",
+		r.Target, len(r.Inserted), len(r.Updated), len(r.Unchanged))
+
+	for _, id := range r.Inserted {
+		fmt.Fprintf(&builder, "+ %s\n", id)
+	}
+
+	for _, diff := range r.Updated {
+		fmt.Fprintf(&builder, "~ %s\n", diff.Id)
+		for _, change := range diff.Changes {
+			old, _ := json.Marshal(change.Old)
+			new, _ := json.Marshal(change.New)
+			fmt.Fprintf(&builder, "\t%s: %s -> %s\n", change.Field, old, new)
+		}
+	}
+
+	return builder.String()
+}
+
+// Attractions merge would write to the target database.
+type DryRunReport struct {
+	Target    string           `json:"target"`
+	Inserted  []string         `json:"insert"`
+	Updated   []AttractionDiff `json:"update"`
+	Unchanged []string         `json:"unchanged"`
+}
+
+type AttractionDiff struct {
+	Id      string        `json:"id"`
+	Changes []FieldChange `json:"changes"`
+}
+
+// Field that differs between the target and the cache, path of nested
+// fields is separated by dots, e.g. description.hours.wkd
+type FieldChange struct {
+	Field string      `json:"field"`
+	Old   interface{} `json:"old"`
+	New   interface{} `json:"new"`
+}
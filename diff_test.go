@@ -0,0 +1,59 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+)
+
+func TestDryRunJsonErrors(t *testing.T) {
+
+	// Empty database without a destinations table.
+	empty := filepath.Join(t.TempDir(), "empty.db")
+	target, err := openTarget(empty, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target.close()
+
+	for _, args := range [][]string{
+		{filepath.Join(t.TempDir(), "missing.db"), "--json"},
+		{empty, "--json"},
+	} {
+
+		var response map[string]string
+		out := dryRun(args, newMemoryStore())
+		if err := json.Unmarshal([]byte(out), &response); err != nil || response["error"] == "" {
+			t.Errorf("dry run of %s returned %q", args[0], out)
+		}
+	}
+
+	// Text mode returns the message as it is.
+	if out := dryRun([]string{empty}, newMemoryStore()); out != "target database has no destinations table, create it with bootstrap-target" {
+		t.Errorf("dry run returned %q", out)
+	}
+}
+
+// Dry run reports a copyright change only when merge would consider the attraction changed.
+func TestDiffCopyright(t *testing.T) {
+
+	for _, test := range []struct {
+		old, new sql.NullString
+		same     bool
+	}{
+		{sql.NullString{}, sql.NullString{String: "", Valid: true}, true},
+		{createNullString("Ona"), createNullString("Ona"), true},
+		{createNullString("Ona"), sql.NullString{}, false},
+		{createNullString("Ona"), createNullString("Jonas"), false},
+	} {
+
+		old, new := testAttraction("vilnius"), testAttraction("vilnius")
+		old.copyright, new.copyright = test.old, test.new
+
+		changes := diffAttractions(&old, &new)
+		if sameValues(&old, &new) != test.same || (len(changes) == 0) != test.same {
+			t.Errorf("copyright %+v -> %+v: same %t, changes %+v", test.old, test.new, sameValues(&old, &new), changes)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+// Example service that receives processed images posted by merge.
+// Run with the same secret as in the server's configuration:
+//
+//	SEND_SECRET=secret go run examples/receiver/main.go
+//
+// and merge with http://127.0.0.1:8081/images as the url.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+
+	"github.com/MingaudasVagonis/go-attractions-server/signature"
+)
+
+// Maximum size of a request body, batches of 20 base64 encoded images fit in it.
+const max_body_size = 64 << 20
+
+func main() {
+
+	secret := []byte(os.Getenv("SEND_SECRET"))
+	if len(secret) == 0 {
+		log.Fatal("SEND_SECRET is not set")
+	}
+
+	http.HandleFunc("/images", func(writer http.ResponseWriter, request *http.Request) {
+
+		// Rejecting forged, modified and replayed requests.
+		body, err := signature.VerifyRequest(request, secret, signature.DefaultTolerance, max_body_size)
+		if errors.Is(err, signature.ErrTooLarge) {
+			http.Error(writer, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		images, err := readImages(request.Header.Get("Content-Type"), body)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		log.Printf("Received %d images: %v", len(images), images)
+
+		// Merge retries images listed with a 5xx or 429 status and reports other failed
+		// images, images that are left out of the results are accepted.
+		results := make([]Result, 0, len(images))
+		for _, img := range images {
+			results = append(results, Result{img.Id, img.Position, http.StatusCreated, ""})
+		}
+
+		writer.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(writer).Encode(results)
+	})
+
+	log.Fatal(http.ListenAndServe("127.0.0.1:8081", nil))
+}
+
+// Attraction's id and position of a received image.
+type Image struct {
+	Id       string `json:"id"`
+	Position int    `json:"position"`
+}
+
+// Result of a received image merge reads from the response.
+type Result struct {
+	Id       string `json:"id"`
+	Position int    `json:"position"`
+	Status   int    `json:"status"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Function takes in the content type and a body posted by merge
+// and returns ids and positions of the images it contains.
+func readImages(content_type string, body []byte) ([]Image, error) {
+
+	media, params, err := mime.ParseMediaType(content_type)
+	if err != nil {
+		return nil, err
+	}
+
+	images := make([]Image, 0)
+
+	switch media {
+
+	case "application/x-ndjson":
+		scanner := bufio.NewScanner(bytes.NewReader(body))
+		// Lines contain whole base64 encoded images.
+		scanner.Buffer(nil, 32<<20)
+		for scanner.Scan() {
+			var line Image
+			if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+				return nil, err
+			}
+			images = append(images, line)
+		}
+		return images, scanner.Err()
+
+	case "multipart/form-data":
+		form, err := multipart.NewReader(bytes.NewReader(body), params["boundary"]).ReadForm(32 << 20)
+		if err != nil {
+			return nil, err
+		}
+		// Metadata of every image is a single json field.
+		if len(form.Value["metadata"]) == 0 {
+			return nil, fmt.Errorf("metadata field is missing")
+		}
+		if err := json.Unmarshal([]byte(form.Value["metadata"][0]), &images); err != nil {
+			return nil, err
+		}
+		return images, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported content type %s", media)
+	}
+}
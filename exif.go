@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+// Distance in kilometers after which the location the photo was taken at
+// is considered to be different from the attraction's location.
+const gps_mismatch_threshold = 2.0
+
+// Function takes in raw image bytes and returns EXIF orientation (1-8) and
+// a reference to the Coordinates the photo was taken at. Orientation defaults
+// to 1 and coordinates to nil if metadata is missing or unreadable.
+func readExif(data []byte) (int, *Coordinates) {
+
+	x, err := exif.Decode(bytes.NewReader(data))
+	if err != nil {
+		return 1, nil
+	}
+
+	orientation := 1
+	if tag, err := x.Get(exif.Orientation); err == nil {
+		if val, err := tag.Int(0); err == nil && val >= 1 && val <= 8 {
+			orientation = val
+		}
+	}
+
+	lat, long, err := x.LatLong()
+	if err != nil {
+		return orientation, nil
+	}
+
+	return orientation, &Coordinates{Latitude: float32(lat), Longitude: float32(long)}
+}
+
+// Function takes in an image.Image and an EXIF orientation and returns
+// a new image.Image that is rotated and/or flipped to be displayed upright.
+func orient(img image.Image, orientation int) image.Image {
+
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+
+	// Orientations 5-8 are rotated by 90 degrees so width and height are swapped.
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+
+	// Drawing the source onto RGBA once so pixel access is cheap.
+	src := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // Mirrored horizontally.
+				dx, dy = w-1-x, y
+			case 3: // Rotated by 180 degrees.
+				dx, dy = w-1-x, h-1-y
+			case 4: // Mirrored vertically.
+				dx, dy = x, h-1-y
+			case 5: // Mirrored horizontally and rotated 270 degrees clockwise.
+				dx, dy = y, x
+			case 6: // Rotated 90 degrees clockwise.
+				dx, dy = h-1-y, x
+			case 7: // Mirrored horizontally and rotated 90 degrees clockwise.
+				dx, dy = h-1-y, w-1-x
+			case 8: // Rotated 270 degrees clockwise.
+				dx, dy = y, w-1-x
+			}
+			dst.SetRGBA(dx, dy, src.RGBAAt(x, y))
+		}
+	}
+
+	return dst
+}
+
+// Function takes in a slice of processed Downloadables and returns a slice of
+// warnings for images which were taken further than gps_mismatch_threshold
+// from the attraction's coordinates.
+func checkLocations(downloadables []Downloadable) []string {
+
+	warnings := make([]string, 0)
+
+	for _, down := range downloadables {
+		// Images without GPS metadata can't be checked.
+		if down.gps == nil {
+			continue
+		}
+		// see utils.go
+		if dist := distance(*down.gps, down.location); dist > gps_mismatch_threshold {
+			warnings = append(warnings, fmt.Sprintf("%s: photo taken %.1f km away", down.key(), dist))
+		}
+	}
+
+	return warnings
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"reflect"
+	"testing"
+)
+
+// Function takes in rows of gray levels and returns a Gray image with them.
+func grayImage(rows [][]uint8) *image.Gray {
+
+	img := image.NewGray(image.Rect(0, 0, len(rows[0]), len(rows)))
+	for y, row := range rows {
+		for x, value := range row {
+			img.SetGray(x, y, color.Gray{value})
+		}
+	}
+	return img
+}
+
+// Function takes in an image.Image and returns its gray levels by rows.
+func grayLevels(img image.Image) [][]uint8 {
+
+	bounds := img.Bounds()
+	rows := make([][]uint8, bounds.Dy())
+	for y := range rows {
+		rows[y] = make([]uint8, bounds.Dx())
+		for x := range rows[y] {
+			rows[y][x] = color.GrayModel.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.Gray).Y
+		}
+	}
+	return rows
+}
+
+func TestOrient(t *testing.T) {
+
+	// Stored image, every pixel has its own level:
+	// 1 2 3
+	// 4 5 6
+	stored := grayImage([][]uint8{{1, 2, 3}, {4, 5, 6}})
+
+	for _, test := range []struct {
+		orientation int
+		upright     [][]uint8
+	}{
+		{0, [][]uint8{{1, 2, 3}, {4, 5, 6}}},
+		{1, [][]uint8{{1, 2, 3}, {4, 5, 6}}},
+		{2, [][]uint8{{3, 2, 1}, {6, 5, 4}}},
+		{3, [][]uint8{{6, 5, 4}, {3, 2, 1}}},
+		{4, [][]uint8{{4, 5, 6}, {1, 2, 3}}},
+		{5, [][]uint8{{1, 4}, {2, 5}, {3, 6}}},
+		{6, [][]uint8{{4, 1}, {5, 2}, {6, 3}}},
+		{7, [][]uint8{{6, 3}, {5, 2}, {4, 1}}},
+		{8, [][]uint8{{3, 6}, {2, 5}, {1, 4}}},
+		{9, [][]uint8{{1, 2, 3}, {4, 5, 6}}},
+	} {
+		if levels := grayLevels(orient(stored, test.orientation)); !reflect.DeepEqual(levels, test.upright) {
+			t.Errorf("orientation %d: got %v, want %v", test.orientation, levels, test.upright)
+		}
+	}
+}
+
+func TestReadExifWithoutMetadata(t *testing.T) {
+
+	if orientation, gps := readExif([]byte("not an image")); orientation != 1 || gps != nil {
+		t.Fatalf("readExif returned %d, %v", orientation, gps)
+	}
+}
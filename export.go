@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Header of the csv export, nested values of attractions are flattened.
+var csv_header = []string{"id", "category", "name", "city", "lat", "lon", "hours_wkd", "hours_std", "hours_snd", "info",
+	"image_url", "copyright"}
+
+// Runs of characters of an attraction's id that aren't used in its bundle paths.
+var regex_bundle_unsafe = regexp.MustCompile("[^a-z0-9]+")
+
+// Function takes in a command split by spaces, a format (json, csv or bundle) and a path,
+// and an AttractionStore. Attractions in the cache are written to the path in the given
+// format. Returns a string with the execution result.
+func export(parts []string, store AttractionStore) string {
+
+	if len(parts) < 3 {
+		return "Usage: export [json|csv|bundle] [path]"
+	}
+
+	format, path := parts[1], parts[2]
+
+	// see db.go
+	attractions, err := readCache(store)
+	if err != nil {
+		return fmt.Sprintf("Failed to export: %s", err.Error())
+	}
+
+	switch format {
+
+	case "json":
+		err = exportJson(attractions, path)
+
+	case "csv":
+		err = exportCsv(attractions, path)
+
+	case "bundle":
+		return exportBundle(attractions, path)
+
+	default:
+		return fmt.Sprintf("Unknown export format %s, expected json, csv or bundle", format)
+	}
+
+	if err != nil {
+		return fmt.Sprintf("Failed to export: %s", err.Error())
+	}
+
+	return fmt.Sprintf("Exported %d attractions to %s", len(attractions), path)
+}
+
+// Function takes in a slice of attractions and a path and writes the
+// attractions as a pretty json array. An error is returned if it occurs.
+func exportJson(attractions []Attraction, path string) error {
+
+	views := make([]AttractionView, 0, len(attractions))
+	for _, a := range attractions {
+		views = append(views, a.view())
+	}
+
+	data, err := json.MarshalIndent(views, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// see imagestore.go
+	return writeAtomic(path, data)
+}
+
+// Function takes in a slice of attractions and a path and writes the attractions
+// as csv with one row per attraction. An error is returned if it occurs.
+func exportCsv(attractions []Attraction, path string) error {
+
+	var buffer bytes.Buffer
+	writer := csv.NewWriter(&buffer)
+
+	if err := writer.Write(csv_header); err != nil {
+		return err
+	}
+
+	for _, a := range attractions {
+		if err := writer.Write(a.flatten()); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return err
+	}
+
+	return writeAtomic(path, buffer.Bytes())
+}
+
+// Function takes in a slice of attractions and a path to a directory and writes a static
+// bundle the frontend can serve without the server: index.json with a summary of every
+// attraction, attractions/<id>.json and images/<id>-<position>.jpg processed the same way
+// merge processes them. Returns a string with the execution result.
+func exportBundle(attractions []Attraction, dir string) string {
+
+	var (
+		toDownload []Downloadable
+		failed     []Failure
+		// Attractions that have a bundle name of their own.
+		bundled = make([]Attraction, 0, len(attractions))
+		// Ids of attractions by their bundle names.
+		names = map[string]string{}
+	)
+
+	for _, a := range attractions {
+
+		name := bundleName(a.id)
+
+		switch other, taken := names[name]; {
+		case name == "":
+			failed = append(failed, Failure{a.id, no_position, "id can't be used as a bundle path"})
+		case taken:
+			failed = append(failed, Failure{a.id, no_position, "same bundle path as " + other})
+		default:
+			names[name] = a.id
+			bundled = append(bundled, a)
+		}
+	}
+
+	attractions = bundled
+
+	// Same pipeline as merge, see retrieve.go
+	getUrls(attractions, newImageStore(config.Store.Root), &toDownload, &failed)
+	download(&toDownload, &failed)
+	process(&toDownload, &failed)
+
+	// Bundle paths of processed images by their keys.
+	processed := map[string]*Downloadable{}
+
+	for ind := range toDownload {
+
+		down := &toDownload[ind]
+
+		data, err := encodeJpeg(*down)
+		if err != nil {
+			failed = append(failed, down.failure("encode failed: "+err.Error()))
+			continue
+		}
+
+		if err := writeBundleFile(dir, down.bundlePath(), data); err != nil {
+			return fmt.Sprintf("Failed to export: %s", err.Error())
+		}
+
+		processed[down.key()] = down
+	}
+
+	index := make([]BundleEntry, 0, len(attractions))
+
+	for _, a := range attractions {
+
+		view := a.view()
+
+		// Only images that made it into the bundle are listed, with their bundle paths.
+		images := make([]ImageView, 0, len(view.Images))
+		view.Image = nil
+
+		for _, iv := range view.Images {
+
+			down, ok := processed[fmt.Sprintf("%s#%d", a.id, iv.Position)]
+			if !ok {
+				continue
+			}
+
+			iv.Url, iv.Hash = down.bundlePath(), ""
+			iv.Blurhash, iv.Colour = down.blurhash, down.colour
+			images = append(images, iv)
+		}
+
+		view.Images = images
+		for ind := range view.Images {
+			if view.Images[ind].Primary {
+				view.Image = &view.Images[ind]
+			}
+		}
+
+		data, err := json.MarshalIndent(view, "", "  ")
+		if err != nil {
+			return fmt.Sprintf("Failed to export: %s", err.Error())
+		}
+
+		path := "attractions/" + bundleName(a.id) + ".json"
+
+		if err := writeBundleFile(dir, path, data); err != nil {
+			return fmt.Sprintf("Failed to export: %s", err.Error())
+		}
+
+		raw := a.raw()
+		entry := BundleEntry{
+			Id:       a.id,
+			Name:     strings.TrimSpace(raw.Description.Name),
+			Category: a.category,
+			City:     raw.Location.City,
+			Path:     path,
+		}
+		if view.Image != nil {
+			entry.Image, entry.Blurhash, entry.Colour = view.Image.Url, view.Image.Blurhash, view.Image.Colour
+		}
+
+		index = append(index, entry)
+	}
+
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return fmt.Sprintf("Failed to export: %s", err.Error())
+	}
+
+	if err := writeBundleFile(dir, "index.json", data); err != nil {
+		return fmt.Sprintf("Failed to export: %s", err.Error())
+	}
+
+	return fmt.Sprintf("Exported %d attractions and %d images to %s.\nFinished with %d failures\n\t%s",
+		len(attractions), len(processed), dir, len(failed), formatFailures(failed))
+}
+
+// Function returns a RawAttraction with attraction's description and location,
+// they are stored in the same shape they were submitted in.
+func (a *Attraction) raw() RawAttraction {
+
+	var ra RawAttraction
+	json.Unmarshal([]byte(a.description), &ra.Description)
+	json.Unmarshal([]byte(a.location), &ra.Location)
+
+	return ra
+}
+
+// Function returns attraction's values flattened into the columns of csv_header.
+func (a *Attraction) flatten() []string {
+
+	ra := a.raw()
+
+	image_url := ""
+	if primary := a.primaryImage(); primary != nil {
+		image_url = primary.url.String
+		if primary.upload.Valid {
+			image_url = v1_prefix + "/images/" + primary.upload.String
+		}
+	}
+
+	coords := ra.Location.Coordinates
+
+	return []string{
+		a.id,
+		a.category,
+		strings.TrimSpace(ra.Description.Name),
+		ra.Location.City,
+		strconv.FormatFloat(float64(coords.Latitude), 'f', -1, 32),
+		strconv.FormatFloat(float64(coords.Longitude), 'f', -1, 32),
+		ra.Description.Hours.Wkd,
+		ra.Description.Hours.Std,
+		ra.Description.Hours.Snd,
+		strings.TrimSpace(ra.Description.Info),
+		image_url,
+		a.copyright.String,
+	}
+}
+
+// Function returns the path of the processed image in a bundle.
+func (d *Downloadable) bundlePath() string {
+	return fmt.Sprintf("images/%s-%d.jpg", bundleName(d.id), d.position)
+}
+
+// Function takes in an attraction's id and returns the name of its files in a bundle. Ids are
+// lowercased and characters other than a-z and 0-9 are replaced with dashes, so no id can
+// name a path outside of the bundle. Returns an empty string if nothing is left.
+func bundleName(id string) string {
+	return strings.Trim(regex_bundle_unsafe.ReplaceAllString(strings.ToLower(id), "-"), "-")
+}
+
+// Function takes in a path to a bundle's directory, a slash separated path in the bundle and
+// data, and writes the data to the file. Paths that lead outside of the directory are refused.
+// An error is returned if it occurs.
+func writeBundleFile(dir string, path string, data []byte) error {
+
+	full := filepath.Join(dir, filepath.FromSlash(path))
+
+	relative, err := filepath.Rel(dir, full)
+	if err != nil || relative == ".." || strings.HasPrefix(relative, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("%s is outside of the bundle", path)
+	}
+
+	// see imagestore.go
+	return writeAtomic(full, data)
+}
+
+// Summary of an attraction in the bundle's index.json.
+type BundleEntry struct {
+	Id       string `json:"id"`
+	Name     string `json:"name"`
+	Category string `json:"category"`
+	City     string `json:"city"`
+	// Path of the attraction's json file in the bundle.
+	Path string `json:"path"`
+	// Path of the primary image in the bundle.
+	Image    string `json:"image,omitempty"`
+	Blurhash string `json:"blurhash,omitempty"`
+	Colour   string `json:"colour,omitempty"`
+}
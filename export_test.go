@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBundleName(t *testing.T) {
+
+	for _, test := range []struct{ id, expected string }{
+		{"vilniauskatedra", "vilniauskatedra"},
+		{"Trakų Pilis", "trak-pilis"},
+		{"../../../tmp/x", "tmp-x"},
+		{"..", ""},
+	} {
+		if name := bundleName(test.id); name != test.expected {
+			t.Errorf("bundleName(%q) = %q, want %q", test.id, name, test.expected)
+		}
+	}
+}
+
+func TestWriteBundleFileStaysInDir(t *testing.T) {
+
+	dir := t.TempDir()
+
+	if err := writeBundleFile(filepath.Join(dir, "bundle"), "../outside.json", []byte("{}")); err == nil {
+		t.Fatal("writing outside of the bundle succeeded")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "outside.json")); !os.IsNotExist(err) {
+		t.Fatal("file outside of the bundle was written")
+	}
+}
+
+func TestExportBundleSkipsUnsafeIds(t *testing.T) {
+
+	root := t.TempDir()
+	dir := filepath.Join(root, "bundle")
+
+	safe, unsafe, same := testAttraction("vilnius"), testAttraction("../../x"), testAttraction("x")
+	for _, a := range []*Attraction{&safe, &unsafe, &same} {
+		a.images = nil
+	}
+
+	exportBundle([]Attraction{safe, unsafe, same}, dir)
+
+	var index []BundleEntry
+	data, err := os.ReadFile(filepath.Join(dir, "index.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &index); err != nil {
+		t.Fatal(err)
+	}
+
+	// The id that sanitizes to x comes first, the real x is left out.
+	if len(index) != 2 || index[0].Path != "attractions/vilnius.json" || index[1].Path != "attractions/x.json" || index[1].Id != "../../x" {
+		t.Fatalf("index is %+v", index)
+	}
+
+	matches, _ := filepath.Glob(filepath.Join(root, "*.json"))
+	if len(matches) != 0 {
+		t.Fatalf("files were written outside of the bundle: %v", matches)
+	}
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Licences an image can be published under.
+var viable_licences = []string{"cc-by", "cc-by-sa", "public-domain", "all-rights-reserved"}
+
+// Regex that matches urls images can be downloaded from.
+var regex_image_url = regexp.MustCompile("^https?://")
+
+// Maximum number of images in an attraction's gallery.
+const max_gallery_size = 20
+
+// Licence assumed for images submitted with the single image field.
+const default_licence = "all-rights-reserved"
+
+// Function takes in a reference to a RawAttraction and ValidationErrors
+// and adds an error for every invalid value of the gallery.
+func (ra *RawAttraction) validateGallery(errs *ValidationErrors) {
+
+	if ra.Image.Url != "" && len(ra.Images) > 0 {
+		errs.add("image", code_image_conflict)
+	}
+
+	if len(ra.Images) > max_gallery_size {
+		errs.add("images", code_gallery_too_large, max_gallery_size)
+	}
+
+	primaries := 0
+
+	for ind, img := range ra.Images {
+
+		path := fmt.Sprintf("images[%d]", ind)
+
+		if !regex_image_url.MatchString(img.Url) {
+			errs.add(path+".url", code_invalid_url)
+		}
+
+		validateLicence(errs, path+".", img.Licence, img.Author)
+
+		if img.Primary {
+			primaries++
+			if primaries == 2 {
+				errs.add(path+".primary", code_multiple_primary)
+			}
+		}
+	}
+}
+
+// Function takes in ValidationErrors, a prefix of the paths, image's licence and author
+// and adds errors if the licence is unknown or requires an author that is missing.
+func validateLicence(errs *ValidationErrors, prefix, licence, author string) {
+
+	if !sliceContains(&licence, viable_licences) {
+		errs.add(prefix+"licence", code_invalid_licence, viable_licences)
+	}
+
+	// Attribution is required by every licence except public domain.
+	if licence != "public-domain" && strings.TrimSpace(author) == "" {
+		errs.add(prefix+"author", code_author_required)
+	}
+}
+
+// Function takes in a reference to a RawAttraction and returns its gallery.
+// Single image is turned into a gallery of one, the first image becomes
+// primary if none is marked as such.
+func (ra *RawAttraction) gallery() []GalleryImage {
+
+	images := make([]GalleryImage, 0, len(ra.Images))
+
+	if ra.Image.Url != "" {
+		images = append(images, GalleryImage{
+			url:     createNullString(ra.Image.Url),
+			author:  createNullString(ra.Image.Copyright),
+			licence: default_licence,
+			primary: true,
+		})
+		return images
+	}
+
+	primary := false
+
+	for ind, img := range ra.Images {
+		images = append(images, GalleryImage{
+			position: ind,
+			url:      createNullString(img.Url),
+			caption:  createNullString(strings.TrimSpace(img.Caption)),
+			author:   createNullString(strings.TrimSpace(img.Author)),
+			licence:  img.Licence,
+			primary:  img.Primary,
+		})
+		primary = primary || img.Primary
+	}
+
+	if !primary && len(images) > 0 {
+		images[0].primary = true
+	}
+
+	return images
+}
+
+// Function returns the attribution text of the image, e.g. "© Author, CC BY-SA".
+func (g *GalleryImage) attribution() string {
+
+	author := g.author.String
+
+	switch g.licence {
+	case "cc-by":
+		return author + ", CC BY"
+	case "cc-by-sa":
+		return author + ", CC BY-SA"
+	case "public-domain":
+		return author
+	}
+
+	if author == "" || strings.HasPrefix(author, "©") {
+		return author
+	}
+
+	return "© " + author
+}
+
+// Function returns the primary image of the attraction's
+// gallery or nil if the gallery is empty.
+func (a *Attraction) primaryImage() *GalleryImage {
+	for ind := range a.images {
+		if a.images[ind].primary {
+			return &a.images[ind]
+		}
+	}
+	return nil
+}
+
+// Function takes in an interface that contains an Exec method (sql.Tx or sql.DB),
+// attraction's id and an unpacked slice of GalleryImages and commits them to the
+// cache. An error is returned if it occurs.
+func commitImages(connection interface {
+	Exec(string, ...interface{}) (sql.Result, error)
+}, id string, images ...GalleryImage) error {
+
+	if len(images) == 0 {
+		return nil
+	}
+
+	values, args := make([]string, 0, len(images)), make([]interface{}, 0, len(images)*11)
+
+	for _, img := range images {
+		values = append(values, "(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
+		args = append(args, id, img.position, img.url, img.caption, img.author, img.licence, img.primary, img.upload,
+			img.phash, img.blurhash, img.colour)
+	}
+
+	stmt := fmt.Sprintf(`INSERT INTO images (attraction_id, position, url, caption, author, licence, is_primary, upload,
+		phash, blurhash, colour) VALUES %s`, strings.Join(values, ","))
+	_, err := connection.Exec(stmt, args...)
+
+	return err
+}
+
+// Function takes in a connection to the cache, an optional attraction's id and
+// reads gallery images of that attraction, or of every attraction if id is empty.
+// Returns a map of attractions' ids to images ordered by position and an error
+// if it occurs.
+func readImages(connection *sql.DB, id string) (map[string][]GalleryImage, error) {
+
+	query := `SELECT attraction_id, position, url, caption, author, licence, is_primary, upload, phash, blurhash, colour, stored
+		FROM images`
+	args := []interface{}{}
+
+	if id != "" {
+		query += " WHERE attraction_id = ?"
+		args = append(args, id)
+	}
+
+	rows, err := connection.Query(query+" ORDER BY attraction_id, position", args...)
+
+	if err != nil {
+		return nil, errors.New("Failed to read cache")
+	}
+
+	defer rows.Close()
+
+	var (
+		images = map[string][]GalleryImage{}
+		// Temporary values to read the row to.
+		tmp_id  string
+		tmp_img GalleryImage
+	)
+
+	for rows.Next() {
+
+		err := rows.Scan(&tmp_id, &tmp_img.position, &tmp_img.url, &tmp_img.caption, &tmp_img.author, &tmp_img.licence,
+			&tmp_img.primary, &tmp_img.upload, &tmp_img.phash, &tmp_img.blurhash, &tmp_img.colour, &tmp_img.stored)
+		if err != nil {
+			return nil, errors.New("Failed to read row")
+		}
+
+		images[tmp_id] = append(images[tmp_id], tmp_img)
+	}
+
+	return images, nil
+}
+
+// Function takes in an attraction's id and an uploaded GalleryImage and adds it to
+// the end of the attraction's gallery. If the image is primary it replaces the previous
+// primary image. Returns the position of the image and an error if it occurs, errGalleryFull
+// if the gallery already has max_gallery_size images.
+func (s *SQLiteStore) addImage(id string, img GalleryImage) (int, error) {
+
+	tx, err := s.connection.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	var exists bool
+	if err := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM destinations WHERE id = ?)", id).Scan(&exists); err != nil || !exists {
+		tx.Rollback()
+		if err == nil {
+			err = errNotFound
+		}
+		return 0, err
+	}
+
+	// Counted in the transaction so concurrent uploads can't both take the last place.
+	var size int
+	err = tx.QueryRow("SELECT COUNT(*), COALESCE(MAX(position) + 1, 0) FROM images WHERE attraction_id = ?", id).Scan(&size, &img.position)
+	if err != nil || size >= max_gallery_size {
+		tx.Rollback()
+		if err == nil {
+			err = errGalleryFull
+		}
+		return 0, err
+	}
+
+	// First image of the gallery is always primary.
+	img.primary = img.primary || img.position == 0
+
+	if img.primary {
+		if _, err := tx.Exec("UPDATE images SET is_primary = 0 WHERE attraction_id = ?", id); err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+		// Target databases only know the attribution of the primary image.
+		if _, err := tx.Exec("UPDATE destinations SET copyright = ? WHERE id = ?", createNullString(img.attribution()), id); err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+	}
+
+	if err := commitImages(tx, id, img); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	return img.position, tx.Commit()
+}
+
+// Function takes in a slice of processed Downloadables and stores their perceptual
+// hashes and placeholders in the cache. An error is returned if it occurs.
+func (s *SQLiteStore) storeProcessed(downloadables []Downloadable) error {
+
+	tx, err := s.connection.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, down := range downloadables {
+		_, err := tx.Exec("UPDATE images SET phash = ?, blurhash = ?, colour = ? WHERE attraction_id = ? AND position = ?",
+			formatHash(down.hash), down.blurhash, down.colour, down.id, down.position)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Function takes in a slice of saved Downloadables and a map of their keys to
+// hashes in the image store and records them in the cache. An error is returned
+// if it occurs.
+func (s *SQLiteStore) storeSaved(downloadables []Downloadable, saved map[string]string) error {
+
+	tx, err := s.connection.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, down := range downloadables {
+		hash, ok := saved[down.key()]
+		if !ok {
+			continue
+		}
+		_, err := tx.Exec("UPDATE images SET stored = ? WHERE attraction_id = ? AND position = ?", hash, down.id, down.position)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+type GalleryImage struct {
+	position int
+	url      sql.NullString
+	caption  sql.NullString
+	author   sql.NullString
+	licence  string
+	primary  bool
+	// Hash of the image uploaded through the API in the image store. Uploads are
+	// processed when they are uploaded, see upload.go
+	upload sql.NullString
+	// Values computed when the image is processed by merge or uploaded.
+	phash    sql.NullString
+	blurhash sql.NullString
+	colour   sql.NullString
+	// Hash of the processed image in the image store.
+	stored sql.NullString
+}
+
+type RawImage struct {
+	Url     string `json:"url"`
+	Caption string `json:"caption"`
+	Author  string `json:"author"`
+	Licence string `json:"licence"`
+	Primary bool   `json:"primary"`
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Function takes in the time a merge run started at and returns its id, the time with
+// microseconds and a random suffix so runs started at the same time get different ids.
+func runID(started time.Time) string {
+
+	suffix := make([]byte, 2)
+	rand.Read(suffix)
+
+	return started.Format("20060102-150405.000000") + "-" + hex.EncodeToString(suffix)
+}
+
+// Statuses of a merge run. Runs are recorded as pending before the target commits and
+// finished as committed or failed afterwards, a run left pending may have been committed.
+const (
+	run_pending   = "pending"
+	run_committed = "committed"
+	run_failed    = "failed"
+)
+
+// Function takes in a merge run's id, the time it started at, a path to the target database
+// and a MergeResult. The run and the rows it writes are recorded as pending in the cache's
+// merge_runs table so the run can be rolled back. An error is returned if it occurs.
+func (s *SQLiteStore) recordRun(id string, started time.Time, target string, result *MergeResult) error {
+
+	rows, err := json.Marshal(result.rows)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.connection.Exec(`INSERT INTO merge_runs (id, started_at, target, inserted, updated, skipped, failed, rows, status)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`, id, started, target, len(result.inserted), len(result.updated),
+		len(result.skipped), "[]", string(rows), run_pending)
+
+	return err
+}
+
+// Function takes in a merge run's id and its status after the target committed
+// or failed to commit and updates the run. An error is returned if it occurs.
+func (s *SQLiteStore) finishRun(id, status string) error {
+	_, err := s.connection.Exec("UPDATE merge_runs SET status = ? WHERE id = ?", status, id)
+	return err
+}
+
+// Function takes in a merge run's id and a slice of Failures and records images
+// that failed during the run. An error is returned if it occurs.
+func (s *SQLiteStore) recordFailures(id string, failed []Failure) error {
+
+	data, err := json.Marshal(failedImages(failed))
+	if err != nil {
+		return err
+	}
+
+	_, err = s.connection.Exec("UPDATE merge_runs SET failed = ? WHERE id = ?", string(data), id)
+
+	return err
+}
+
+// Function takes in a merge run's id. Returns a reference to the MergeRun,
+// or nil if it doesn't exist, and an error if it occurs.
+func (s *SQLiteStore) readRun(id string) (*MergeRun, error) {
+
+	var (
+		run          = MergeRun{id: id}
+		rows, failed string
+	)
+
+	err := s.connection.QueryRow("SELECT started_at, target, status, failed, rows, rolled_back_at FROM merge_runs WHERE id = ?", id).
+		Scan(&run.started, &run.target, &run.status, &failed, &rows, &run.rolled_back)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal([]byte(rows), &run.rows); err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal([]byte(failed), &run.failed); err != nil {
+		return nil, err
+	}
+
+	return &run, nil
+}
+
+// Function takes in a merge run's id, marks the run as rolled back and removes
+// its attractions from the merged table so the next merge merges them again.
+// An error is returned if it occurs.
+func (s *SQLiteStore) markRolledBack(id string) error {
+
+	tx, err := s.connection.Begin()
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM merged WHERE batch = ?", id); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if _, err := tx.Exec("UPDATE merge_runs SET rolled_back_at = ? WHERE id = ?", time.Now().UTC(), id); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Function takes in a merge run's id, a target database url, which is empty to use the one the run
+// was recorded with, and an AttractionStore the run is recorded in and restores the target database
+// to its state before the run: inserted rows are deleted and updated rows get their previous values.
+// Rows that changed in the target since the run are not touched and the rollback is refused.
+// Returns a string with the execution result.
+func rollback(id string, target_url string, store AttractionStore) string {
+
+	run, err := store.readRun(id)
+	if err != nil {
+		return fmt.Sprintf("Failed to read merge run: %s", err.Error())
+	}
+	if run == nil {
+		return fmt.Sprintf("Merge run %s not found", id)
+	}
+
+	if run.rolled_back.Valid {
+		return fmt.Sprintf("Merge run %s was already rolled back at %s", id, run.rolled_back.Time.Format(time.RFC3339))
+	}
+
+	// Pending runs are rolled back too, the target may have committed before the run was finished.
+	if run.status == run_failed {
+		return fmt.Sprintf("Merge run %s failed to commit, nothing to roll back", id)
+	}
+
+	// Runs are recorded without the target's credentials, a url that has them has to point
+	// to the same database. Runs recorded before may still have them, see target.go
+	recorded := redactTarget(run.target)
+	if target_url == "" {
+		target_url = run.target
+	} else if redactTarget(target_url) != recorded {
+		return fmt.Sprintf("Merge run %s was written to %s, not %s", id, recorded, redactTarget(target_url))
+	}
+
+	// Opening a missing SQLite database would create an empty one.
+	if !isPostgres(target_url) {
+		if _, err := os.Stat(target_url); err != nil {
+			return fmt.Sprintf("Failed to open target database: %s", err.Error())
+		}
+	}
+
+	// see target.go
+	target, err := openTarget(target_url, false)
+	if err != nil {
+		return fmt.Sprintf("Failed to open target database: %s", err.Error())
+	}
+
+	defer target.close()
+
+	if err := target.verify(); err != nil {
+		return fmt.Sprintf("Failed to roll back: %s", err.Error())
+	}
+
+	tx, err := target.connection.Begin()
+	if err != nil {
+		return fmt.Sprintf("Failed to roll back: %s", err.Error())
+	}
+
+	// Attractions whose rows no longer hold the values written by the run.
+	changed := make([]string, 0)
+
+	// Undoing rows in the reverse order they were written in.
+	for i := len(run.rows) - 1; i >= 0; i-- {
+
+		row := run.rows[i]
+
+		current, err := target.readAttraction(tx, row.Id)
+		if err != nil {
+			tx.Rollback()
+			return fmt.Sprintf("Failed to roll back %s: %s", row.Id, err.Error())
+		}
+
+		after := row.After.attraction(row.Id)
+		if current == nil || !sameValues(current, &after) {
+			changed = append(changed, row.Id)
+			continue
+		}
+
+		if row.Before == nil {
+			_, err = tx.Exec(target.rebind("DELETE FROM destinations WHERE id = ?"), row.Id)
+		} else {
+			err = target.write(tx, row.Id, *row.Before, true)
+		}
+
+		if err != nil {
+			tx.Rollback()
+			return fmt.Sprintf("Failed to roll back %s: %s", row.Id, err.Error())
+		}
+	}
+
+	if len(changed) > 0 {
+		tx.Rollback()
+		return fmt.Sprintf("Refusing to roll back %s, attractions changed in the target since the run:\n\t%s",
+			id, strings.Join(changed, "\n\t"))
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Sprintf("Failed to roll back: %s", err.Error())
+	}
+
+	// Attractions of the run are merged again by the next merge.
+	if err := store.markRolledBack(id); err != nil {
+		return fmt.Sprintf("Rolled back %s but failed to update the cache: %s", id, err.Error())
+	}
+
+	return fmt.Sprintf("Rolled back %s: %d rows restored in %s", id, len(run.rows), recorded)
+}
+
+// Function takes in a slice of Failures and returns them as FailedImages.
+func failedImages(failed []Failure) []FailedImage {
+
+	images := make([]FailedImage, 0, len(failed))
+	for _, f := range failed {
+		images = append(images, FailedImage{f.key(), f.reason})
+	}
+
+	return images
+}
+
+// Function takes in a reference to an Attraction and returns
+// the values it has in the target database.
+func targetRow(a *Attraction) TargetRow {
+
+	row := TargetRow{Category: a.category, Description: a.description, Location: a.location}
+
+	if a.copyright.Valid {
+		row.Copyright = &a.copyright.String
+	}
+
+	return row
+}
+
+// Function takes in an attraction's id and returns an Attraction
+// with the values of the row, used to compare checksums.
+func (r TargetRow) attraction(id string) Attraction {
+
+	a := Attraction{id: id, category: r.Category, description: r.Description, location: r.Location}
+
+	if r.Copyright != nil {
+		a.copyright = sql.NullString{String: *r.Copyright, Valid: true}
+	}
+
+	return a
+}
+
+// Merge run recorded in the merge_runs table.
+type MergeRun struct {
+	id      string
+	started time.Time
+	target  string
+	// One of run_pending, run_committed, run_failed.
+	status string
+	// Rows written to the target in the order they were written.
+	rows        []MergeRow
+	failed      []FailedImage
+	rolled_back sql.NullTime
+}
+
+// Row written to the target database by a merge run.
+type MergeRow struct {
+	Id string `json:"id"`
+	// Values before the run, nil if the row was inserted.
+	Before *TargetRow `json:"before"`
+	After  TargetRow  `json:"after"`
+}
+
+// Values of an attraction's row in the target database.
+type TargetRow struct {
+	Category    string  `json:"category"`
+	Description string  `json:"description"`
+	Location    string  `json:"location"`
+	Copyright   *string `json:"copyright"`
+}
+
+// Image that failed during a merge run and the reason why.
+type FailedImage struct {
+	Id     string `json:"id"`
+	Reason string `json:"reason"`
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/nfnt/resize"
+	"github.com/oliamb/cutter"
+)
+
+// Largest rendition dimension that can be configured in config.Store.Renditions.
+const max_rendition_size = 2400
+
+// Store of jpeg images kept under a root directory and addressed by
+// the SHA-256 of their contents, so equal images are stored once and
+// stored files never change.
+type ImageStore struct {
+	root string
+}
+
+// Function takes in a root directory and returns a reference to an ImageStore.
+func newImageStore(root string) *ImageStore {
+	return &ImageStore{root}
+}
+
+// Function takes in image bytes, stores them unless they are already stored
+// and returns their hash and an error if it occurs.
+func (is *ImageStore) put(data []byte) (string, error) {
+
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+
+	path := is.path(hash)
+
+	if _, err := os.Stat(path); err == nil {
+		return hash, nil
+	}
+
+	return hash, writeAtomic(path, data)
+}
+
+// Function takes in a hash and returns the stored image bytes and an error if it occurs.
+func (is *ImageStore) get(hash string) ([]byte, error) {
+	return ioutil.ReadFile(is.path(hash))
+}
+
+// Function takes in a hash and returns the path of the stored image. Images are
+// split into subdirectories by the first two characters of the hash.
+func (is *ImageStore) path(hash string) string {
+	return filepath.Join(is.root, hash[:2], hash+".jpg")
+}
+
+// Function takes in a hash and dimensions and returns the path of the rendition.
+func (is *ImageStore) renditionPath(hash string, w, h uint) string {
+	return filepath.Join(is.root, "renditions", hash[:2], fmt.Sprintf("%s-%dx%d.jpg", hash, w, h))
+}
+
+// Function takes in a hash of a stored image and rendition dimensions (0 keeps the
+// aspect ratio). Returns the rendition bytes, generating and storing it if it
+// doesn't exist yet, and an error if it occurs.
+func (is *ImageStore) rendition(hash string, w, h uint) ([]byte, error) {
+
+	path := is.renditionPath(hash, w, h)
+
+	if data, err := ioutil.ReadFile(path); err == nil {
+		return data, nil
+	}
+
+	original, err := is.get(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(original))
+	if err != nil {
+		return nil, err
+	}
+
+	if w > 0 && h > 0 {
+		// Resizing to cover the requested area and cropping the rest.
+		bounds := img.Bounds()
+		if float64(bounds.Dx())/float64(bounds.Dy()) > float64(w)/float64(h) {
+			img = resize.Resize(0, h, img, resize.Bicubic)
+		} else {
+			img = resize.Resize(w, 0, img, resize.Bicubic)
+		}
+		img, err = cutter.Crop(img, cutter.Config{Width: int(w), Height: int(h), Mode: cutter.Centered})
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		img = resize.Resize(w, h, img, resize.Bicubic)
+	}
+
+	var buffer bytes.Buffer
+	if err := jpeg.Encode(&buffer, img, &jpeg.Options{Quality: 80}); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), writeAtomic(path, buffer.Bytes())
+}
+
+// Function takes in a path and bytes and writes them to a temporary file
+// in the same directory which is then renamed, so readers never see a
+// partially written file. An error is returned if it occurs.
+func writeAtomic(path string, data []byte) error {
+
+	dir := filepath.Dir(path)
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+
+	// Removing the temporary file if anything fails, after renaming it's a no-op.
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// Route handler to serve a stored image or its rendition.
+// Function takes in the standart handler parameters http.ResponseWriter and a reference
+// to a http.Request with optional w and h query parameters and reponds with an error
+// or a jpeg image.
+func (s *Server) serveImage(writer http.ResponseWriter, request *http.Request) {
+
+	hash := mux.Vars(request)["hash"]
+
+	var dims [2]uint
+	for ind, key := range []string{"w", "h"} {
+		value := request.FormValue(key)
+		if value == "" {
+			continue
+		}
+		parsed, err := strconv.Atoi(value)
+		if err != nil || !intContains(parsed, config.Store.Renditions) {
+			// see messages.go
+			respondError(writer, request, apiError(http.StatusBadRequest, code_invalid_rendition, key, config.Store.Renditions))
+			return
+		}
+		dims[ind] = uint(parsed)
+	}
+
+	var (
+		data []byte
+		err  error
+		etag = hash
+	)
+
+	if dims[0] == 0 && dims[1] == 0 {
+		data, err = s.images.get(hash)
+	} else {
+		data, err = s.images.rendition(hash, dims[0], dims[1])
+		etag = fmt.Sprintf("%s-%dx%d", hash, dims[0], dims[1])
+	}
+
+	if os.IsNotExist(err) {
+		respondError(writer, request, apiError(http.StatusNotFound, code_image_not_found))
+		return
+	}
+	if err != nil {
+		respondError(writer, request, apiError(http.StatusInternalServerError, code_image_read_failed))
+		return
+	}
+
+	// Stored images never change so they can be cached forever.
+	writer.Header().Set("ETag", strconv.Quote(etag))
+	writer.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	writer.Header().Set("Content-Type", "image/jpeg")
+
+	// Handles If-None-Match and range requests.
+	http.ServeContent(writer, request, "", time.Time{}, bytes.NewReader(data))
+}
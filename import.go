@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// Attraction fields read from imported spreadsheets, the columns of the csv export without the id.
+var import_fields = []string{"category", "name", "city", "lat", "lon", "hours_wkd", "hours_std", "hours_snd", "info",
+	"image_url", "copyright"}
+
+// Fields that may be left out of imported spreadsheets.
+var optional_import_fields = []string{"image_url", "copyright"}
+
+// Header of the report of rejected rows.
+var import_report_header = []string{"row", "name", "reason"}
+
+// Function takes in a command split by spaces, a format (csv or xlsx) and a path to the
+// spreadsheet, and an AttractionStore. Valid rows are added to the store in a single
+// transaction, rejected rows are written to <path>.errors.csv with their reasons.
+// Returns a string with the execution result.
+func importAttractions(parts []string, store AttractionStore) string {
+
+	if len(parts) < 3 {
+		return "Usage: import [csv|xlsx] [path]"
+	}
+
+	format, path := parts[1], parts[2]
+
+	var (
+		rows [][]string
+		err  error
+	)
+
+	switch format {
+
+	case "csv":
+		rows, err = readCsvRows(path)
+
+	case "xlsx":
+		rows, err = readXlsxRows(path)
+
+	default:
+		return fmt.Sprintf("Unknown import format %s, expected csv or xlsx", format)
+	}
+
+	if err != nil {
+		return fmt.Sprintf("Failed to import: %s", err.Error())
+	}
+
+	if len(rows) == 0 {
+		return fmt.Sprintf("Failed to import: %s is empty", path)
+	}
+
+	columns, err := mapColumns(rows[0])
+	if err != nil {
+		return fmt.Sprintf("Failed to import: %s", err.Error())
+	}
+
+	// Existing titles used to reject duplicates, see store.go
+	titles, err := store.titles()
+	if err != nil {
+		return fmt.Sprintf("Failed to import: %s", err.Error())
+	}
+
+	var (
+		accepted []Attraction
+		rejected []ImportRejection
+		// Number of rows that weren't empty.
+		total int
+	)
+
+	for ind, row := range rows[1:] {
+
+		if isEmptyRow(row) {
+			continue
+		}
+
+		total++
+
+		// Row numbers as shown by spreadsheet editors, the header is the first row.
+		number := ind + 2
+
+		ra, err := columns.attraction(row)
+		if err == nil {
+			// see attraction.go
+			err = ra.validate()
+		}
+		if err == nil {
+			err = checkDuplicate(toID(ra.Description.Name), titles, accepted)
+		}
+
+		if err != nil {
+			rejected = append(rejected, ImportRejection{number, strings.TrimSpace(columns.value(row, "name")), err.Error()})
+			continue
+		}
+
+		accepted = append(accepted, ra.wrap())
+	}
+
+	if len(accepted) > 0 {
+		// see store.go
+		if err := store.addAll(accepted); err != nil {
+			return fmt.Sprintf("Failed to import: %s\nNo attractions were added", err.Error())
+		}
+	}
+
+	result := fmt.Sprintf("Imported %d of %d rows from %s", len(accepted), total, path)
+
+	if len(rejected) > 0 {
+
+		report := path + ".errors.csv"
+
+		if err := writeImportReport(report, rejected); err != nil {
+			return fmt.Sprintf("%s\nFailed to write rejected rows: %s", result, err.Error())
+		}
+
+		result += fmt.Sprintf("\nRejected %d rows, see %s", len(rejected), report)
+	}
+
+	return result
+}
+
+// Function takes in a path to a csv file and returns its rows
+// and an error if it occurs.
+func readCsvRows(path string) ([][]string, error) {
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	// Spreadsheet editors leave out trailing empty cells.
+	reader.FieldsPerRecord = -1
+
+	var rows [][]string
+
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// Blank lines are skipped by the reader, empty rows keep
+		// row numbers the same as the lines of the file.
+		line, _ := reader.FieldPos(0)
+		for len(rows) < line-1 {
+			rows = append(rows, nil)
+		}
+
+		rows = append(rows, row)
+	}
+
+	// Files saved by Excel start with a byte order mark.
+	if len(rows) > 0 && len(rows[0]) > 0 {
+		rows[0][0] = strings.TrimPrefix(rows[0][0], "\ufeff")
+	}
+
+	return rows, nil
+}
+
+// Function takes in a path to a xlsx workbook and returns rows of the configured
+// sheet, or the first sheet, and an error if it occurs.
+func readXlsxRows(path string) ([][]string, error) {
+
+	workbook, err := excelize.OpenFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer workbook.Close()
+
+	sheet := config.Import.Sheet
+
+	if sheet == "" {
+		sheets := workbook.GetSheetList()
+		if len(sheets) == 0 {
+			return nil, errors.New("workbook has no sheets")
+		}
+		sheet = sheets[0]
+	}
+
+	return workbook.GetRows(sheet)
+}
+
+// Function takes in the header row of a spreadsheet and returns ImportColumns with
+// indexes of the configured columns. An error is returned if a required column is missing.
+func mapColumns(header []string) (ImportColumns, error) {
+
+	columns := ImportColumns{}
+	var missing []string
+
+	for _, field := range import_fields {
+
+		name := config.Import.Columns[field]
+
+		for ind, cell := range header {
+			if name != "" && strings.EqualFold(strings.TrimSpace(cell), name) {
+				columns[field] = ind
+				break
+			}
+		}
+
+		if _, ok := columns[field]; !ok && !sliceContains(&field, optional_import_fields) {
+			missing = append(missing, fmt.Sprintf("%s (%s)", name, field))
+		}
+	}
+
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("spreadsheet is missing columns: %s", strings.Join(missing, ", "))
+	}
+
+	return columns, nil
+}
+
+// Function takes in a row and returns the trimmed value of the field's column,
+// empty if the column isn't mapped or the row is shorter.
+func (c ImportColumns) value(row []string, field string) string {
+
+	ind, ok := c[field]
+	if !ok || ind >= len(row) {
+		return ""
+	}
+
+	return strings.TrimSpace(row[ind])
+}
+
+// Function takes in a row and returns a reference to a RawAttraction with its values
+// and an error if a value can't be parsed. Values aren't validated.
+func (c ImportColumns) attraction(row []string) (*RawAttraction, error) {
+
+	var ra RawAttraction
+
+	ra.Category = c.value(row, "category")
+	ra.Description.Name = c.value(row, "name")
+	ra.Description.Info = c.value(row, "info")
+	ra.Description.Hours.Wkd = c.value(row, "hours_wkd")
+	ra.Description.Hours.Std = c.value(row, "hours_std")
+	ra.Description.Hours.Snd = c.value(row, "hours_snd")
+	ra.Location.City = c.value(row, "city")
+	ra.Image.Url = c.value(row, "image_url")
+	ra.Image.Copyright = c.value(row, "copyright")
+
+	// Spreadsheets localised for Lithuania use decimal commas.
+	lat, err := strconv.ParseFloat(strings.Replace(c.value(row, "lat"), ",", ".", 1), 32)
+	if err != nil {
+		return nil, errors.New("Invalid latitude")
+	}
+
+	lon, err := strconv.ParseFloat(strings.Replace(c.value(row, "lon"), ",", ".", 1), 32)
+	if err != nil {
+		return nil, errors.New("Invalid longitude")
+	}
+
+	ra.Location.Coordinates = Coordinates{float32(lat), float32(lon)}
+
+	return &ra, nil
+}
+
+// Function takes in an id of an imported attraction, existing titles and attractions
+// accepted earlier in the same import. Returns an error naming the first attraction
+// the id is similar to, the same way /check finds them.
+func checkDuplicate(id string, titles *TitleValues, accepted []Attraction) error {
+
+	// compareID requires at least a single character.
+	if id == "" {
+		return errors.New("Name is invalid")
+	}
+
+	for ind, val := range titles.compares {
+		// see utils.go
+		if val != "" && compareID(id, val) >= match_threshold {
+			return fmt.Errorf("Similar to existing attraction %s", titles.displays[ind])
+		}
+	}
+
+	for _, a := range accepted {
+		if compareID(id, a.id) >= match_threshold {
+			return fmt.Errorf("Similar to attraction %s earlier in the file", a.name)
+		}
+	}
+
+	return nil
+}
+
+// Function takes in a row and returns whether all of its cells are empty.
+func isEmptyRow(row []string) bool {
+	for _, cell := range row {
+		if strings.TrimSpace(cell) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// Function takes in a path and rejected rows and writes them as csv.
+// An error is returned if it occurs.
+func writeImportReport(path string, rejected []ImportRejection) error {
+
+	var buffer bytes.Buffer
+	writer := csv.NewWriter(&buffer)
+
+	if err := writer.Write(import_report_header); err != nil {
+		return err
+	}
+
+	for _, r := range rejected {
+		if err := writer.Write([]string{strconv.Itoa(r.row), r.name, r.reason}); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return err
+	}
+
+	// see imagestore.go
+	return writeAtomic(path, buffer.Bytes())
+}
+
+// Function returns the default column mapping, headers are the field names.
+func importColumns() map[string]string {
+
+	columns := make(map[string]string, len(import_fields))
+	for _, field := range import_fields {
+		columns[field] = field
+	}
+
+	return columns
+}
+
+// Indexes of spreadsheet columns keyed by the attraction fields in import_fields.
+type ImportColumns map[string]int
+
+// Row of an imported spreadsheet that wasn't added and the reason why.
+type ImportRejection struct {
+	row    int
+	name   string
+	reason string
+}
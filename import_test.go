@@ -0,0 +1,168 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Opening hours and info shared by the rows of the fixtures.
+const test_import_values = `08:00-20:00,09:00-18:00,10:00-16:00,Description that is longer than thirty characters`
+
+// Function takes in a test and the contents of a csv file and returns its path in a temporary directory.
+func writeTestCsv(t *testing.T, contents string) string {
+
+	path := filepath.Join(t.TempDir(), "attractions.csv")
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestImportAttractions(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store AttractionStore) {
+
+		existing := testAttraction("klaipeda")
+		if err := store.add(&existing); err != nil {
+			t.Fatal(err)
+		}
+
+		path := writeTestCsv(t, "\ufeffcategory,name,city,lat,lon,hours_wkd,hours_std,hours_snd,info\n"+
+			`nature,Vilniaus parkas,Vilnius,"54,68","25,28",`+test_import_values+"\n"+
+			"\n"+
+			"heritage,Trakų pilis,Trakai,54.65,24.93,"+test_import_values+"\n"+
+			"nature,Vilniaus parkas,Vilnius,54.69,25.29,"+test_import_values+"\n"+
+			"museums,Kauno muziejus,Kaunas,abc,23.9,"+test_import_values+"\n"+
+			"nature,Rygos parkas,Ryga,56.95,24.1,"+test_import_values+"\n"+
+			"nature,Klaipeda,Klaipėda,55.7,21.1,"+test_import_values+"\n")
+
+		out := importAttractions([]string{"import", "csv", path}, store)
+		if !strings.HasPrefix(out, "Imported 2 of 6 rows from "+path+"\nRejected 4 rows") {
+			t.Fatalf("import returned %q", out)
+		}
+
+		// Row numbers are the lines of the file, counting the blank one.
+		report, err := os.ReadFile(path + ".errors.csv")
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := "row,name,reason\n" +
+			"5,Vilniaus parkas,Similar to attraction Vilniaus parkas earlier in the file\n" +
+			"6,Kauno muziejus,Invalid latitude\n" +
+			"7,Rygos parkas,location.coordinates.latitude: Location is outside of Lithuania\n" +
+			"8,Klaipeda,Similar to existing attraction klaipeda\n"
+		if string(report) != expected {
+			t.Fatalf("report is\n%s\nwant\n%s", report, expected)
+		}
+
+		// Decimal commas are read as decimal points.
+		park, err := store.get("vilniausparkas")
+		if err != nil || park == nil {
+			t.Fatalf("get returned %+v, %v", park, err)
+		}
+		if coords := park.coordinates(); coords != (Coordinates{54.68, 25.28}) {
+			t.Fatalf("coordinates are %+v", coords)
+		}
+
+		list, err := store.list()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(list) != 3 {
+			t.Fatalf("store has %d attractions, want 3", len(list))
+		}
+	})
+}
+
+func TestImportWithoutRejectionsWritesNoReport(t *testing.T) {
+
+	path := writeTestCsv(t, "category,name,city,lat,lon,hours_wkd,hours_std,hours_snd,info\n"+
+		"heritage,Trakų pilis,Trakai,54.65,24.93,"+test_import_values+"\n")
+
+	if out := importAttractions([]string{"import", "csv", path}, newMemoryStore()); out != "Imported 1 of 1 rows from "+path {
+		t.Fatalf("import returned %q", out)
+	}
+	if _, err := os.Stat(path + ".errors.csv"); !os.IsNotExist(err) {
+		t.Fatal("report was written without rejected rows")
+	}
+}
+
+func TestReadCsvRows(t *testing.T) {
+
+	rows, err := readCsvRows(writeTestCsv(t, "\ufeffname,city\n\n\nTrakų pilis,Trakai\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rows) != 4 || rows[0][0] != "name" || rows[1] != nil || rows[2] != nil || rows[3][0] != "Trakų pilis" {
+		t.Fatalf("rows are %q", rows)
+	}
+}
+
+func TestMapColumns(t *testing.T) {
+
+	previous := config.Import.Columns
+	defer func() { config.Import.Columns = previous }()
+
+	config.Import.Columns = importColumns()
+	config.Import.Columns["name"], config.Import.Columns["city"] = "Pavadinimas", "Miestas"
+
+	header := []string{"category", " pavadinimas ", "MIESTAS", "lat", "lon", "hours_wkd", "hours_std", "hours_snd", "info", "copyright"}
+	columns, err := mapColumns(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if columns["name"] != 1 || columns["city"] != 2 || columns["copyright"] != 9 {
+		t.Fatalf("columns are %v", columns)
+	}
+	if _, ok := columns["image_url"]; ok {
+		t.Fatal("missing optional column was mapped")
+	}
+
+	row := []string{"nature", " Trakų pilis "}
+	if name, city := columns.value(row, "name"), columns.value(row, "city"); name != "Trakų pilis" || city != "" {
+		t.Fatalf("values are %q, %q", name, city)
+	}
+
+	_, err = mapColumns([]string{"category", "name", "lat"})
+	if err == nil || !strings.HasPrefix(err.Error(), "spreadsheet is missing columns: Pavadinimas (name), Miestas (city), lon (lon)") {
+		t.Fatalf("mapColumns returned %v", err)
+	}
+}
+
+func TestCheckDuplicate(t *testing.T) {
+
+	titles := &TitleValues{compares: []string{"trakpilis"}, displays: []string{"Trakų pilis"}}
+	accepted := []Attraction{{id: "vilniausparkas", name: "Vilniaus parkas"}}
+
+	for _, test := range []struct{ id, expected string }{
+		{"", "Name is invalid"},
+		{"trakpilis", "Similar to existing attraction Trakų pilis"},
+		{"vilniausparkas", "Similar to attraction Vilniaus parkas earlier in the file"},
+		{"kaunomuziejus", ""},
+	} {
+
+		err := checkDuplicate(test.id, titles, accepted)
+		if (err == nil && test.expected != "") || (err != nil && err.Error() != test.expected) {
+			t.Errorf("checkDuplicate(%q) returned %v, want %q", test.id, err, test.expected)
+		}
+	}
+}
+
+func TestWriteImportReport(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "report.csv")
+	err := writeImportReport(path, []ImportRejection{{2, `Parkas, "Senas"`, "Invalid latitude"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "row,name,reason\n2,\"Parkas, \"\"Senas\"\"\",Invalid latitude\n"; string(data) != expected {
+		t.Fatalf("report is %q, want %q", data, expected)
+	}
+}
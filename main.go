@@ -3,39 +3,52 @@ package main
 import (
 	"bufio"
 	"fmt"
+	"log"
 	"os"
 	"strings"
 )
 
 func main() {
 
+	// see config.go
+	if err := loadConfig(config_path); err != nil {
+		log.Fatal("Failed to load configuration: ", err)
+	}
+
+	// Opening the cache database shared by the server and commands, see db.go
+	store, err := openSQLiteStore(cache_path)
+	if err != nil {
+		log.Fatal("Failed to open cache: ", err)
+	}
+
 	// Listening for commands in a goroutine because the
 	// http server blocks the thread after it starts.
-	go listenForCommands()
+	go listenForCommands(store)
 
-	server := Server{url: "127.0.0.1:8080"}
+	server := Server{url: "127.0.0.1:8080", store: store}
 	server.Start()
 }
 
-// Funcion scans command line input and executes a specific command.
-func listenForCommands() {
+// Funcion takes in an AttractionStore, scans command line input
+// and executes a specific command.
+func listenForCommands(store AttractionStore) {
 
 	input := bufio.NewScanner(os.Stdin)
 	input.Scan()
 
-	result := handleCommand(input.Text())
+	result := handleCommand(input.Text(), store)
 	fmt.Println(result)
 
 }
 
-// Function takes in the input from command line and executes
+// Function takes in the input from command line and an AttractionStore and executes
 // a function accordingly. Returns a string with an execution status.
-func handleCommand(command string) string {
+func handleCommand(command string, store AttractionStore) string {
 	parts := strings.Split(command, " ")
 
 	// Continue to listen for commands after execution.
 	defer func() {
-		go listenForCommands()
+		go listenForCommands(store)
 	}()
 
 	switch parts[0] {
@@ -45,15 +58,47 @@ func handleCommand(command string) string {
 		if len(parts) < 2 {
 			return "No destination db provided"
 		}
+		// Comparing with the target without writing anything, see diff.go
+		if parts[1] == "--dry-run" {
+			return dryRun(parts[2:], store)
+		}
 		// see retrieve.go
-		return merge(parts)
+		return merge(parts, store)
 	case "initialize":
 		// Command requires a path tp the database
 		if len(parts) < 2 {
 			return "No destination db provided"
 		}
 		// see db.go
-		return initializeTitles(parts[1])
+		return initializeTitles(parts[1], store)
+	case "bootstrap-target":
+		// Command requires a path to the database
+		if len(parts) < 2 {
+			return "No destination db provided"
+		}
+		// see target.go
+		return bootstrapTarget(parts[1])
+	case "export":
+		// see export.go
+		return export(parts, store)
+	case "import":
+		// see import.go
+		return importAttractions(parts, store)
+	case "migrate":
+		// see migrate.go
+		return migrate(store)
+	case "rollback":
+		// Command requires an id of the merge run
+		if len(parts) < 2 {
+			return "No merge run id provided"
+		}
+		// Optional target database url with the credentials the run was recorded without.
+		target := ""
+		if len(parts) > 2 {
+			target = parts[2]
+		}
+		// see history.go
+		return rollback(parts[1], target, store)
 	default:
 		return "Command not recognized"
 	}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// AttractionStore that keeps attractions in memory, used in tests and
+// anywhere the cache database isn't needed, see store.go
+type MemoryStore struct {
+	mutex       sync.Mutex
+	attractions map[string]Attraction
+	title_list  []Title
+	// Merged attractions' ids to their merge batch ids and checksums.
+	merged_ids map[string][2]string
+	runs       map[string]*MergeRun
+}
+
+// Function returns a reference to an empty MemoryStore.
+func newMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		attractions: map[string]Attraction{},
+		merged_ids:  map[string][2]string{},
+		runs:        map[string]*MergeRun{},
+	}
+}
+
+func (m *MemoryStore) add(a *Attraction) error {
+	return m.addAll([]Attraction{*a})
+}
+
+// Function takes in a slice of Attractions and adds copies of them with their titles.
+// None are added if one of them already exists or is repeated, an error is returned instead.
+func (m *MemoryStore) addAll(attractions []Attraction) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// Checking every attraction first so nothing is added if one fails.
+	ids := map[string]bool{}
+	for _, a := range attractions {
+		if _, ok := m.attractions[a.id]; ok || ids[a.id] {
+			return &ExistsError{a.id}
+		}
+		ids[a.id] = true
+	}
+
+	for _, a := range attractions {
+		m.attractions[a.id] = copyAttraction(a)
+		m.title_list = append(m.title_list, Title{a.id, a.name})
+	}
+
+	return nil
+}
+
+func (m *MemoryStore) get(id string) (*Attraction, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	a, ok := m.attractions[id]
+	if !ok {
+		return nil, nil
+	}
+
+	a = copyAttraction(a)
+
+	return &a, nil
+}
+
+func (m *MemoryStore) list() ([]Attraction, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	attractions := make([]Attraction, 0, len(m.attractions))
+	for _, a := range m.attractions {
+		attractions = append(attractions, copyAttraction(a))
+	}
+
+	sort.Slice(attractions, func(i, j int) bool { return attractions[i].id < attractions[j].id })
+
+	return attractions, nil
+}
+
+func (m *MemoryStore) update(a *Attraction) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	existing, ok := m.attractions[a.id]
+	if !ok {
+		return errNotFound
+	}
+
+	existing.category, existing.description, existing.location = a.category, a.description, a.location
+	existing.url, existing.copyright = a.url, a.copyright
+	m.attractions[a.id] = existing
+
+	return nil
+}
+
+func (m *MemoryStore) delete(id string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, ok := m.attractions[id]; !ok {
+		return errNotFound
+	}
+
+	delete(m.attractions, id)
+	delete(m.merged_ids, id)
+
+	titles := m.title_list[:0]
+	for _, t := range m.title_list {
+		if t.compare != id {
+			titles = append(titles, t)
+		}
+	}
+	m.title_list = titles
+
+	return nil
+}
+
+func (m *MemoryStore) titles() (*TitleValues, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return getTitleFields(m.title_list), nil
+}
+
+func (m *MemoryStore) addTitles(titles ...Title) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.title_list = append(m.title_list, titles...)
+
+	return nil
+}
+
+func (m *MemoryStore) addImage(id string, img GalleryImage) (int, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	a, ok := m.attractions[id]
+	if !ok {
+		return 0, errNotFound
+	}
+
+	if len(a.images) >= max_gallery_size {
+		return 0, errGalleryFull
+	}
+
+	img.position = 0
+	for _, existing := range a.images {
+		if existing.position >= img.position {
+			img.position = existing.position + 1
+		}
+	}
+
+	// First image of the gallery is always primary.
+	img.primary = img.primary || img.position == 0
+
+	if img.primary {
+		for ind := range a.images {
+			a.images[ind].primary = false
+		}
+		a.copyright = createNullString(img.attribution())
+	}
+
+	a.images = append(a.images, img)
+	m.attractions[id] = a
+
+	return img.position, nil
+}
+
+func (m *MemoryStore) hashes() ([]ImageHash, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var hashes []ImageHash
+
+	for id, a := range m.attractions {
+		for _, img := range a.images {
+			if !img.phash.Valid {
+				continue
+			}
+			hash, err := parseHash(img.phash.String)
+			if err != nil {
+				return nil, err
+			}
+			hashes = append(hashes, ImageHash{id, img.position, hash})
+		}
+	}
+
+	return hashes, nil
+}
+
+func (m *MemoryStore) storeProcessed(downloadables []Downloadable) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, down := range downloadables {
+		m.updateImage(down.id, down.position, func(img *GalleryImage) {
+			img.phash = createNullString(formatHash(down.hash))
+			img.blurhash = createNullString(down.blurhash)
+			img.colour = createNullString(down.colour)
+		})
+	}
+
+	return nil
+}
+
+func (m *MemoryStore) storeSaved(downloadables []Downloadable, saved map[string]string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, down := range downloadables {
+		if hash, ok := saved[down.key()]; ok {
+			m.updateImage(down.id, down.position, func(img *GalleryImage) {
+				img.stored = createNullString(hash)
+			})
+		}
+	}
+
+	return nil
+}
+
+func (m *MemoryStore) merged() (map[string]string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	merged := make(map[string]string, len(m.merged_ids))
+	for id, entry := range m.merged_ids {
+		merged[id] = entry[1]
+	}
+
+	return merged, nil
+}
+
+func (m *MemoryStore) markMerged(attractions []Attraction, batch string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, attr := range attractions {
+		m.merged_ids[attr.id] = [2]string{batch, attr.checksum()}
+	}
+
+	return nil
+}
+
+func (m *MemoryStore) recordRun(id string, started time.Time, target string, result *MergeResult) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, ok := m.runs[id]; ok {
+		return errors.New("Merge run already exists")
+	}
+
+	m.runs[id] = &MergeRun{id: id, started: started, target: target, status: run_pending, rows: result.rows}
+
+	return nil
+}
+
+func (m *MemoryStore) finishRun(id, status string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if run, ok := m.runs[id]; ok {
+		run.status = status
+	}
+
+	return nil
+}
+
+func (m *MemoryStore) recordFailures(id string, failed []Failure) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if run, ok := m.runs[id]; ok {
+		run.failed = failedImages(failed)
+	}
+
+	return nil
+}
+
+func (m *MemoryStore) readRun(id string) (*MergeRun, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	run, ok := m.runs[id]
+	if !ok {
+		return nil, nil
+	}
+
+	copied := *run
+
+	return &copied, nil
+}
+
+func (m *MemoryStore) markRolledBack(id string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for attr_id, entry := range m.merged_ids {
+		if entry[0] == id {
+			delete(m.merged_ids, attr_id)
+		}
+	}
+
+	if run, ok := m.runs[id]; ok {
+		run.rolled_back = sql.NullTime{Time: time.Now().UTC(), Valid: true}
+	}
+
+	return nil
+}
+
+func (m *MemoryStore) close() error {
+	return nil
+}
+
+// Function takes in an attraction's id, an image's position and a function that
+// changes the image. Must be called with the mutex locked.
+func (m *MemoryStore) updateImage(id string, position int, change func(*GalleryImage)) {
+
+	a, ok := m.attractions[id]
+	if !ok {
+		return
+	}
+
+	for ind := range a.images {
+		if a.images[ind].position == position {
+			change(&a.images[ind])
+		}
+	}
+}
+
+// Function takes in an Attraction and returns a copy that doesn't
+// share the gallery with the original.
+func copyAttraction(a Attraction) Attraction {
+	a.images = append([]GalleryImage(nil), a.images...)
+	return a
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Codes of errors the API responds with, validation codes are in validation.go
+// Codes are stable, clients use them to localise messages.
+const (
+	// Request body isn't valid json.
+	code_invalid_json = "invalid_json"
+	// Value in the request body has a wrong type.
+	code_invalid_type = "invalid_type"
+	// Request body contains a field the attraction doesn't have.
+	code_unknown_field = "unknown_field"
+	code_empty_body    = "empty_body"
+	// Mode of POST /add/batch isn't one of batch_modes.
+	code_invalid_mode = "invalid_mode"
+	// Batch is empty or contains more than max_batch_size attractions.
+	code_invalid_batch_size = "invalid_batch_size"
+	// Attraction of a batch already exists in the cache.
+	code_attraction_exists = "attraction_exists"
+	// Attraction of a batch repeats an earlier item.
+	code_same_as_item = "same_as_item"
+	// Json body is larger than the route accepts.
+	code_json_too_large    = "json_too_large"
+	code_not_found         = "not_found"
+	code_image_not_found   = "image_not_found"
+	code_invalid_rendition = "invalid_rendition"
+	// Image url of GET /check/image couldn't be downloaded.
+	code_image_fetch_failed  = "image_fetch_failed"
+	code_image_decode_failed = "image_decode_failed"
+	code_image_read_failed   = "image_read_failed"
+	// Uploaded image doesn't meet one of the quality rules, see quality.go
+	code_image_too_small  = "image_too_small"
+	code_image_upscaled   = "image_upscaled"
+	code_image_too_blurry = "image_too_blurry"
+	// Image couldn't be cropped to the 3:2 aspect ratio.
+	code_image_crop_failed  = "image_crop_failed"
+	code_body_too_large     = "body_too_large"
+	code_missing_image      = "missing_image"
+	code_unsupported_type   = "unsupported_type"
+	code_encode_failed      = "encode_failed"
+	code_image_store_failed = "image_store_failed"
+	// Cache couldn't be read or written, the cause is only logged.
+	code_internal_error = "internal_error"
+)
+
+// Languages messages are available in, the first one is used when the
+// request doesn't accept any of them.
+var supported_languages = []string{"en", "lt"}
+
+// Message catalogue keyed by language and error code. Messages are fmt formats,
+// the arguments are the same in every language.
+var messages = map[string]map[string]string{
+	"en": {
+		code_invalid_json:        "Request body is not valid json",
+		code_invalid_type:        "Request body contains an invalid value for the %q field (at position %d)",
+		code_unknown_field:       "Request body contains unknown field %s",
+		code_empty_body:          "Request body is empty",
+		code_invalid_mode:        "Mode must be one of %v",
+		code_invalid_batch_size:  "Batch must contain between 1 and %d attractions",
+		code_attraction_exists:   "Attraction already exists",
+		code_same_as_item:        "Same attraction as item %d",
+		code_json_too_large:      "Request body is too large",
+		code_not_found:           "Attraction not found",
+		code_image_not_found:     "Image not found",
+		code_invalid_rendition:   "%s must be one of %v",
+		code_image_fetch_failed:  "Failed to download image",
+		code_image_decode_failed: "Failed to decode image",
+		code_image_read_failed:   "Failed to read image",
+		code_image_too_small:     "Image is %dx%d, minimum is %dx%d",
+		code_image_upscaled:      "Image would be upscaled by %.2f, maximum is %.2f",
+		code_image_too_blurry:    "Image sharpness is %.1f, minimum is %.1f",
+		code_image_crop_failed:   "Failed to crop image",
+		code_body_too_large:      "Request body must be multipart form smaller than %d bytes",
+		code_missing_image:       "Request body contains no image file",
+		code_unsupported_type:    "Image type %s is not supported, must be one of %v",
+		code_encode_failed:       "Failed to encode image",
+		code_image_store_failed:  "Failed to store image",
+		code_internal_error:      "Failed to access database",
+
+		code_too_short:         "Must be longer than %d characters",
+		code_invalid_city:      "City must be longer than 3 characters and contain only lithuanian letters",
+		code_invalid_hours:     "Open hours must match HH:MM-HH:MM",
+		code_invalid_category:  "Category must be one of %v",
+		code_outside_lithuania: "Location is outside of Lithuania",
+		code_image_conflict:    "Use either image or images, not both",
+		code_gallery_too_large: "Gallery can't contain more than %d images",
+		code_invalid_url:       "Image url must start with http:// or https://",
+		code_invalid_licence:   "Licence must be one of %v",
+		code_author_required:   "Author is required",
+		code_multiple_primary:  "Only one image can be primary",
+		code_required:          "Value is required",
+		code_wrong_type:        "Must be %s",
+	},
+	"lt": {
+		code_invalid_json:        "Užklausos turinys nėra tinkamas json",
+		code_invalid_type:        "Užklausos turinyje netinkama lauko %q reikšmė (pozicija %d)",
+		code_unknown_field:       "Užklausos turinyje yra nežinomas laukas %s",
+		code_empty_body:          "Užklausos turinys tuščias",
+		code_invalid_mode:        "Režimas turi būti vienas iš %v",
+		code_invalid_batch_size:  "Rinkinyje turi būti nuo 1 iki %d lankytinų vietų",
+		code_attraction_exists:   "Lankytina vieta jau yra",
+		code_same_as_item:        "Ta pati lankytina vieta kaip elementas %d",
+		code_json_too_large:      "Užklausos turinys per didelis",
+		code_not_found:           "Lankytina vieta nerasta",
+		code_image_not_found:     "Nuotrauka nerasta",
+		code_invalid_rendition:   "%s turi būti vienas iš %v",
+		code_image_fetch_failed:  "Nepavyko atsisiųsti nuotraukos",
+		code_image_decode_failed: "Nepavyko nuskaityti nuotraukos",
+		code_image_read_failed:   "Nepavyko perskaityti nuotraukos",
+		code_image_too_small:     "Nuotrauka yra %dx%d, mažiausiai %dx%d",
+		code_image_upscaled:      "Nuotrauka būtų padidinta %.2f karto, daugiausiai %.2f",
+		code_image_too_blurry:    "Nuotraukos ryškumas yra %.1f, mažiausiai %.1f",
+		code_image_crop_failed:   "Nepavyko apkarpyti nuotraukos",
+		code_body_too_large:      "Užklausos turinys turi būti multipart forma, mažesnė nei %d baitų",
+		code_missing_image:       "Užklausoje nėra nuotraukos failo",
+		code_unsupported_type:    "Nuotraukos tipas %s nepalaikomas, turi būti vienas iš %v",
+		code_encode_failed:       "Nepavyko užkoduoti nuotraukos",
+		code_image_store_failed:  "Nepavyko išsaugoti nuotraukos",
+		code_internal_error:      "Nepavyko pasiekti duomenų bazės",
+
+		code_too_short:         "Simbolių skaičius turi būti didesnis nei %d",
+		code_invalid_city:      "Miestas turi būti ilgesnis nei 3 simboliai ir sudarytas tik iš lietuviškų raidžių",
+		code_invalid_hours:     "Darbo laikas turi atitikti formatą HH:MM-HH:MM",
+		code_invalid_category:  "Kategorija turi būti viena iš %v",
+		code_outside_lithuania: "Vieta yra už Lietuvos ribų",
+		code_image_conflict:    "Naudokite image arba images, ne abu",
+		code_gallery_too_large: "Galerijoje negali būti daugiau nei %d nuotraukų",
+		code_invalid_url:       "Nuotraukos nuoroda turi prasidėti http:// arba https://",
+		code_invalid_licence:   "Licencija turi būti viena iš %v",
+		code_author_required:   "Autorius privalomas",
+		code_multiple_primary:  "Tik viena nuotrauka gali būti pagrindinė",
+		code_required:          "Reikšmė privaloma",
+		code_wrong_type:        "Reikšmė turi būti tipo %s",
+	},
+}
+
+// Error the API responds with, its message is looked up in the catalogue by code.
+type ApiError struct {
+	status int
+	code   string
+	// Values formatted into the message.
+	args []interface{}
+}
+
+// Function takes in a status code, an error code and values formatted
+// into the message and returns a reference to an ApiError.
+func apiError(status int, code string, args ...interface{}) *ApiError {
+	return &ApiError{status, code, args}
+}
+
+// Function returns the english message of the error.
+func (e *ApiError) Error() string {
+	return message(supported_languages[0], e.code, e.args...)
+}
+
+// Function takes in a language, an error code and values formatted into the message
+// and returns the message. English is used if the language has no such message.
+func message(lang, code string, args ...interface{}) string {
+
+	format, ok := messages[lang][code]
+	if !ok {
+		format, ok = messages[supported_languages[0]][code]
+	}
+	if !ok {
+		return code
+	}
+
+	return fmt.Sprintf(format, args...)
+}
+
+// Function takes in a reference to a http.Request and returns the supported language
+// its Accept-Language header prefers, or the default language.
+func language(request *http.Request) string {
+
+	type accepted struct {
+		lang    string
+		quality float64
+	}
+
+	var langs []accepted
+
+	for _, part := range strings.Split(request.Header.Get("Accept-Language"), ",") {
+
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		// Only the primary subtag is used, lt-LT is lt.
+		lang := strings.ToLower(strings.SplitN(fields[0], "-", 2)[0])
+		quality := 1.0
+
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					quality = q
+				}
+			}
+		}
+
+		if quality > 0 && sliceContains(&lang, supported_languages) {
+			langs = append(langs, accepted{lang, quality})
+		}
+	}
+
+	if len(langs) == 0 {
+		return supported_languages[0]
+	}
+
+	// Languages of the same quality keep the order of the header.
+	sort.SliceStable(langs, func(i, j int) bool { return langs[i].quality > langs[j].quality })
+
+	return langs[0].lang
+}
+
+// Function takes in http.ResponseWriter, a reference to the http.Request and an error and
+// responds with the error's message in the request's language. ValidationErrors are responded
+// with 422, ApiErrors with their status and any other error is logged and responded with 500.
+func respondError(writer http.ResponseWriter, request *http.Request, err error) {
+
+	lang := language(request)
+	writer.Header().Set("Content-Language", lang)
+
+	var (
+		invalid ValidationErrors
+		api     *ApiError
+	)
+
+	switch {
+
+	// see validation.go
+	case errors.As(err, &invalid):
+		invalid = invalid.localise(lang)
+		respond(writer, http.StatusUnprocessableEntity, map[string]interface{}{
+			"error":  invalid.Error(),
+			"errors": invalid,
+		})
+
+	case errors.As(err, &api):
+		respond(writer, api.status, map[string]string{"error": message(lang, api.code, api.args...), "code": api.code})
+
+	default:
+		// Causes of internal errors aren't shown to users.
+		log.Printf("%s %s: %s", request.Method, request.URL.Path, err.Error())
+		respond(writer, http.StatusInternalServerError, map[string]string{
+			"error": message(lang, code_internal_error), "code": code_internal_error})
+	}
+}
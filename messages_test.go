@@ -0,0 +1,36 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// Messages of every language take the same arguments as the english ones.
+func TestMessagesTakeSameArguments(t *testing.T) {
+
+	english := messages[supported_languages[0]]
+
+	for _, lang := range supported_languages[1:] {
+		for code, format := range english {
+
+			translated, ok := messages[lang][code]
+			if !ok {
+				t.Errorf("%s has no %s message", code, lang)
+				continue
+			}
+			if strings.Count(translated, "%") != strings.Count(format, "%") {
+				t.Errorf("%s message of %s takes different arguments: %q, %q", lang, code, translated, format)
+			}
+		}
+	}
+}
+
+func TestMessageIsLocalised(t *testing.T) {
+
+	if message := message("lt", code_image_too_blurry, 0.0, 1.0); message != "Nuotraukos ryškumas yra 0.0, mažiausiai 1.0" {
+		t.Fatalf("lithuanian message is %q", message)
+	}
+	if message := message("de", code_not_found); message != "Attraction not found" {
+		t.Fatalf("message of an unsupported language is %q", message)
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Migrations that create and upgrade the cache database schema, applied in order.
+// Applied versions are recorded in the schema_migrations table. New migrations are
+// appended to the end, applied ones are never changed.
+var migrations = []Migration{
+	{1, "destinations and titles", []string{
+		`CREATE TABLE IF NOT EXISTS destinations (id TEXT PRIMARY KEY NOT NULL, category TEXT NOT NULL, location TEXT NOT NULL,
+			description TEXT NOT NULL, copyright TEXT, url TEXT)`,
+		`CREATE TABLE IF NOT EXISTS titles (compare TEXT NOT NULL, display TEXT NOT NULL)`,
+	}},
+	{2, "image galleries", []string{
+		`CREATE TABLE IF NOT EXISTS images (attraction_id TEXT NOT NULL, position INTEGER NOT NULL, url TEXT, caption TEXT,
+			author TEXT, licence TEXT NOT NULL, is_primary INTEGER NOT NULL DEFAULT 0, upload TEXT, phash TEXT, blurhash TEXT,
+			colour TEXT, stored TEXT, PRIMARY KEY (attraction_id, position))`,
+		// Attractions added before galleries get their single image as a gallery of one.
+		`INSERT INTO images (attraction_id, position, url, author, licence, is_primary)
+			SELECT id, 0, url, copyright, 'all-rights-reserved', 1 FROM destinations
+			WHERE url IS NOT NULL AND id NOT IN (SELECT attraction_id FROM images)`,
+		// Tables that kept one image per attraction are moved into the gallery. They are
+		// created when missing so the statements below work on any older cache:
+		// uploads (uploaded image, see upload.go) becomes a gallery image with the upload hash,
+		// image_hashes (perceptual hash, see phash.go) becomes phash of the first image,
+		// placeholders (see placeholder.go) become blurhash and colour of the first image,
+		// saved_images (see imagestore.go) becomes stored of the first image.
+		`CREATE TABLE IF NOT EXISTS uploads (id TEXT PRIMARY KEY NOT NULL, hash TEXT NOT NULL)`,
+		`CREATE TABLE IF NOT EXISTS image_hashes (id TEXT PRIMARY KEY NOT NULL, hash TEXT NOT NULL)`,
+		`CREATE TABLE IF NOT EXISTS placeholders (id TEXT PRIMARY KEY NOT NULL, blurhash TEXT NOT NULL, colour TEXT NOT NULL)`,
+		`CREATE TABLE IF NOT EXISTS saved_images (id TEXT PRIMARY KEY NOT NULL, hash TEXT NOT NULL)`,
+		`INSERT INTO images (attraction_id, position, licence, is_primary, upload)
+			SELECT id, (SELECT COALESCE(MAX(position) + 1, 0) FROM images WHERE attraction_id = uploads.id),
+			'all-rights-reserved', NOT EXISTS (SELECT 1 FROM images WHERE attraction_id = uploads.id), hash FROM uploads
+			WHERE hash NOT IN (SELECT upload FROM images WHERE upload IS NOT NULL)`,
+		`UPDATE images SET phash = (SELECT hash FROM image_hashes WHERE id = images.attraction_id)
+			WHERE position = 0 AND phash IS NULL AND attraction_id IN (SELECT id FROM image_hashes)`,
+		`UPDATE images SET blurhash = (SELECT blurhash FROM placeholders WHERE id = images.attraction_id),
+			colour = (SELECT colour FROM placeholders WHERE id = images.attraction_id)
+			WHERE position = 0 AND blurhash IS NULL AND attraction_id IN (SELECT id FROM placeholders)`,
+		`UPDATE images SET stored = (SELECT hash FROM saved_images WHERE id = images.attraction_id)
+			WHERE position = 0 AND stored IS NULL AND attraction_id IN (SELECT id FROM saved_images)`,
+		`DROP TABLE uploads`,
+		`DROP TABLE image_hashes`,
+		`DROP TABLE placeholders`,
+		`DROP TABLE saved_images`,
+	}},
+	// Checksum of the merged values is used to find attractions that changed since.
+	{3, "merged attractions", []string{
+		`CREATE TABLE IF NOT EXISTS merged (id TEXT PRIMARY KEY NOT NULL, batch TEXT NOT NULL, merged_at TIMESTAMP NOT NULL,
+			checksum TEXT NOT NULL)`,
+	}},
+	// see history.go
+	{4, "merge runs", []string{
+		`CREATE TABLE IF NOT EXISTS merge_runs (id TEXT PRIMARY KEY NOT NULL, started_at TIMESTAMP NOT NULL, target TEXT NOT NULL,
+			inserted INTEGER NOT NULL, updated INTEGER NOT NULL, skipped INTEGER NOT NULL, failed TEXT NOT NULL, rows TEXT NOT NULL,
+			rolled_back_at TIMESTAMP)`,
+	}},
+	// Runs are recorded before the target commits, runs recorded earlier were committed.
+	{5, "merge run status", []string{
+		`ALTER TABLE merge_runs ADD COLUMN status TEXT NOT NULL DEFAULT 'committed'`,
+	}},
+}
+
+// Function takes in a connection to the cache and applies migrations newer than
+// its schema version, each in its own transaction. Returns a slice of applied
+// Migrations and an error if it occurs.
+func migrateCache(connection *sql.DB) ([]Migration, error) {
+
+	_, err := connection.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY NOT NULL,
+		description TEXT NOT NULL, applied_at TIMESTAMP NOT NULL)`)
+	if err != nil {
+		return nil, err
+	}
+
+	version, err := cacheVersion(connection)
+	if err != nil {
+		return nil, err
+	}
+
+	applied := make([]Migration, 0)
+
+	for _, m := range migrations {
+
+		if m.version <= version {
+			continue
+		}
+
+		if err := m.apply(connection); err != nil {
+			return applied, fmt.Errorf("migration %d (%s) failed: %s", m.version, m.description, err.Error())
+		}
+
+		applied = append(applied, m)
+	}
+
+	return applied, nil
+}
+
+// Function takes in a connection to the cache and returns the version of
+// its schema, 0 if no migrations were applied, and an error if it occurs.
+func cacheVersion(connection interface {
+	QueryRow(string, ...interface{}) *sql.Row
+}) (int, error) {
+
+	var exists int
+	err := connection.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations'").
+		Scan(&exists)
+
+	if err != nil || exists == 0 {
+		return 0, err
+	}
+
+	var version int
+	err = connection.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version)
+
+	return version, err
+}
+
+// Function applies the migration's statements and records its version
+// in a single transaction. An error is returned if it occurs.
+func (m *Migration) apply(connection *sql.DB) error {
+
+	tx, err := connection.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, stmt := range m.statements {
+		if _, err := tx.Exec(stmt); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	_, err = tx.Exec("INSERT INTO schema_migrations (version, description, applied_at) VALUES (?, ?, ?)",
+		m.version, m.description, time.Now().UTC())
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Function takes in an AttractionStore and migrates its cache database to the
+// latest schema version. Returns a string with the execution result.
+func migrate(store AttractionStore) string {
+
+	sqlite, ok := store.(*SQLiteStore)
+	if !ok {
+		return "Store has no schema to migrate"
+	}
+
+	applied, err := migrateCache(sqlite.connection)
+
+	lines := make([]string, 0, len(applied))
+	for _, m := range applied {
+		lines = append(lines, fmt.Sprintf("%d: %s", m.version, m.description))
+	}
+
+	if err != nil {
+		return fmt.Sprintf("Failed to migrate cache: %s\nApplied %d migrations\n\t%s", err.Error(), len(applied), strings.Join(lines, "\n\t"))
+	}
+
+	if len(applied) == 0 {
+		return fmt.Sprintf("Cache schema is up to date (version %d)", latestVersion())
+	}
+
+	return fmt.Sprintf("Migrated cache schema to version %d\n\t%s", latestVersion(), strings.Join(lines, "\n\t"))
+}
+
+// Function returns the version of the last migration.
+func latestVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// Cache database schema change.
+type Migration struct {
+	version     int
+	description string
+	statements  []string
+}
@@ -0,0 +1,417 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Path the OpenAPI document is served at, relative to the version's prefix.
+const openapi_path = "/openapi.json"
+
+// Path of the interactive documentation of the API, relative to the version's prefix.
+const docs_path = "/docs"
+
+// Swagger UI page rendering the OpenAPI document, the document's url is relative so
+// every version's page shows its own routes. Requests can be sent from the page.
+const docs_page = `<!DOCTYPE html>
+<html lang="en">
+<head>
+	<meta charset="utf-8">
+	<title>Attractions server API</title>
+	<link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5.17.14/swagger-ui.css">
+</head>
+<body>
+	<div id="swagger-ui"></div>
+	<script src="https://unpkg.com/swagger-ui-dist@5.17.14/swagger-ui-bundle.js" crossorigin></script>
+	<script>
+		window.ui = SwaggerUIBundle({url: "openapi.json", dom_id: "#swagger-ui"});
+	</script>
+</body>
+</html>
+`
+
+// OpenAPI document describing every route of the server and the deprecated aliases, see createRoutes.
+var api_spec = openapiSpec()
+
+// Language negotiation header accepted by every route, see messages.go
+var accept_language = Parameter{
+	Name:        "Accept-Language",
+	In:          "header",
+	Description: "Language of error messages, en or lt",
+	Schema:      &Schema{Type: []string{"string"}},
+}
+
+// OpenAPI 3.1 document, schemas are JSON Schema draft 2020-12 the same as schema.go
+type OpenAPI struct {
+	Openapi string      `json:"openapi"`
+	Info    OpenAPIInfo `json:"info"`
+	// Operations keyed by path and lowercase method.
+	Paths      map[string]map[string]*Operation `json:"paths"`
+	Components struct {
+		Schemas map[string]*Schema `json:"schemas"`
+	} `json:"components"`
+}
+
+type OpenAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type Operation struct {
+	Summary     string               `json:"summary"`
+	Parameters  []Parameter          `json:"parameters,omitempty"`
+	RequestBody *RequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*Response `json:"responses"`
+	// Unversioned aliases are deprecated, see versions.go
+	Deprecated bool `json:"deprecated,omitempty"`
+}
+
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Function returns the OpenAPI document of the server's routes. Response schemas are
+// generated from the Go types the handlers respond with.
+func openapiSpec() *OpenAPI {
+
+	spec := &OpenAPI{
+		Openapi: "3.1.0",
+		Info:    OpenAPIInfo{Title: "Attractions server", Version: "1"},
+	}
+
+	// The attraction schema without the keywords of a standalone document.
+	attraction := *attraction_schema
+	attraction.Schema, attraction.Id = "", ""
+
+	spec.Components.Schemas = map[string]*Schema{
+		"Attraction":     &attraction,
+		"AttractionView": schemaOf(reflect.TypeOf(AttractionView{})),
+		"BatchResult":    schemaOf(reflect.TypeOf(BatchResult{})),
+		"HashMatch":      schemaOf(reflect.TypeOf(HashMatch{})),
+		"Error": object(map[string]*Schema{
+			"error": {Type: []string{"string"}, Description: "Message in the request's language"},
+			"code":  {Type: []string{"string"}, Description: "Stable error code, see messages.go"},
+		}),
+		"ValidationError": object(map[string]*Schema{
+			"error":  {Type: []string{"string"}, Description: "Paths and messages of all errors"},
+			"errors": {Type: []string{"array"}, Items: schemaOf(reflect.TypeOf(FieldError{}))},
+		}),
+		"Upload": object(map[string]*Schema{
+			"position": {Type: []string{"integer"}},
+			"hash":     {Type: []string{"string"}},
+			"warnings": {Type: []string{"array"}, Items: &Schema{Type: []string{"string"}}},
+		}),
+	}
+
+	ref := func(name string) *Schema {
+		return &Schema{Ref: "#/components/schemas/" + name}
+	}
+	array := func(items *Schema) *Schema {
+		return &Schema{Type: []string{"array"}, Items: items}
+	}
+	query := func(name, description string, required bool, schema *Schema) Parameter {
+		return Parameter{Name: name, In: "query", Description: description, Required: required, Schema: schema}
+	}
+
+	errorResponse := jsonResponse("Error", ref("Error"))
+	invalidResponse := jsonResponse("Invalid values", ref("ValidationError"))
+
+	// Paths relative to the version's prefix.
+	paths := map[string]map[string]*Operation{
+		"/add": {"post": {
+			Summary:     "Add an attraction",
+			RequestBody: jsonBody(ref("Attraction")),
+			Responses: map[string]*Response{
+				"200": {Description: "Attraction was added"},
+				"400": errorResponse,
+				"422": invalidResponse,
+				"500": errorResponse,
+			},
+		}},
+		"/add/batch": {"post": {
+			Summary: "Add several attractions at once",
+			Parameters: []Parameter{
+				query("mode", "Whether invalid attractions reject the whole batch", false,
+					&Schema{Type: []string{"string"}, Enum: batch_modes}),
+			},
+			RequestBody: jsonBody(&Schema{Type: []string{"array"}, Items: ref("Attraction"), MaxItems: intPointer(max_batch_size)}),
+			Responses: map[string]*Response{
+				"200": jsonResponse("Results in the order of the request", array(ref("BatchResult"))),
+				"400": errorResponse,
+				"413": errorResponse,
+				"422": invalidResponse,
+				"500": errorResponse,
+			},
+		}},
+		"/check": {"get": {
+			Summary: "Get names of similar attractions",
+			Parameters: []Parameter{
+				query("name", "Name of the attraction", true, &Schema{Type: []string{"string"}}),
+			},
+			Responses: map[string]*Response{
+				"200": jsonResponse("Names of similar attractions", array(&Schema{Type: []string{"string"}})),
+				"404": {Description: "Name is missing, plain text response of the router"},
+				"500": errorResponse,
+			},
+		}},
+		"/check/image": {"get": {
+			Summary: "Get attractions with a similar image",
+			Parameters: []Parameter{
+				query("url", "Url of the image", true, &Schema{Type: []string{"string"}}),
+			},
+			Responses: map[string]*Response{
+				"200": jsonResponse("Images of attractions that look the same", array(ref("HashMatch"))),
+				"400": errorResponse,
+				"404": {Description: "Url is missing, plain text response of the router"},
+				"500": errorResponse,
+			},
+		}},
+		"/attractions": {"get": {
+			Summary: "List attractions in the cache",
+			Responses: map[string]*Response{
+				"200": jsonResponse("Attractions ordered by id", array(ref("AttractionView"))),
+				"500": errorResponse,
+			},
+		}},
+		"/attractions/{id}": {"get": {
+			Summary:    "Get a single attraction",
+			Parameters: []Parameter{pathParameter("id", &Schema{Type: []string{"string"}})},
+			Responses: map[string]*Response{
+				"200": jsonResponse("Attraction", ref("AttractionView")),
+				"404": errorResponse,
+				"500": errorResponse,
+			},
+		}},
+		"/attractions/{id}/images": {"post": {
+			Summary:    "Upload an image to the attraction's gallery",
+			Parameters: []Parameter{pathParameter("id", &Schema{Type: []string{"string"}})},
+			RequestBody: &RequestBody{Required: true, Content: map[string]MediaType{
+				"multipart/form-data": {Schema: object(map[string]*Schema{
+					"image":   {Type: []string{"string"}, Format: "binary", Description: fmt.Sprintf("One of %v", upload_types)},
+					"caption": {Type: []string{"string"}},
+					"author":  {Type: []string{"string"}},
+					"licence": {Type: []string{"string"}, Enum: viable_licences},
+					"primary": {Type: []string{"string"}, Enum: []string{"true", "false"}},
+				})},
+			}},
+			Responses: map[string]*Response{
+				"200": jsonResponse("Image was added", ref("Upload")),
+				"400": errorResponse,
+				"404": errorResponse,
+				"413": errorResponse,
+				"415": errorResponse,
+				"422": jsonResponse("Invalid licence or author, or an image that doesn't meet the quality rules",
+					&Schema{OneOf: []*Schema{ref("ValidationError"), ref("Error")}}),
+				"500": errorResponse,
+			},
+		}},
+		"/images/{hash}": {"get": {
+			Summary: "Get a stored image or its rendition",
+			Parameters: []Parameter{
+				pathParameter("hash", &Schema{Type: []string{"string"}, Pattern: "^[0-9a-f]{64}$"}),
+				query("w", "Width of the rendition, one of store.renditions", false, &Schema{Type: []string{"integer"},
+					Minimum: floatPointer(1), Maximum: floatPointer(max_rendition_size)}),
+				query("h", "Height of the rendition, one of store.renditions", false, &Schema{Type: []string{"integer"},
+					Minimum: floatPointer(1), Maximum: floatPointer(max_rendition_size)}),
+			},
+			Responses: map[string]*Response{
+				"200": {Description: "Jpeg image", Content: map[string]MediaType{
+					"image/jpeg": {Schema: &Schema{Type: []string{"string"}, Format: "binary"}},
+				}},
+				"304": {Description: "Image matches If-None-Match"},
+				"400": errorResponse,
+				"404": errorResponse,
+				"500": errorResponse,
+			},
+		}},
+		schema_path: {"get": {
+			Summary: "Get the JSON Schema of the attraction",
+			Responses: map[string]*Response{
+				"200": jsonResponse("JSON Schema draft 2020-12", &Schema{Type: []string{"object"}}),
+			},
+		}},
+		openapi_path: {"get": {
+			Summary: "Get this document",
+			Responses: map[string]*Response{
+				"200": jsonResponse("OpenAPI 3.1 document", &Schema{Type: []string{"object"}}),
+			},
+		}},
+		docs_path: {"get": {
+			Summary: "Browse and try out this document",
+			Responses: map[string]*Response{
+				"200": {Description: "Swagger UI page", Content: map[string]MediaType{
+					"text/html": {Schema: &Schema{Type: []string{"string"}}},
+				}},
+			},
+		}},
+	}
+
+	spec.Paths = map[string]map[string]*Operation{}
+
+	for path, operations := range paths {
+
+		spec.Paths[v1_prefix+path] = map[string]*Operation{}
+
+		for method, operation := range operations {
+			operation.Parameters = append(operation.Parameters, accept_language)
+			spec.Paths[v1_prefix+path][method] = operation
+		}
+
+		// Only routes that existed before versioning have aliases, see versions.go
+		if !sliceContains(&path, unversioned_paths) {
+			continue
+		}
+
+		spec.Paths[path] = map[string]*Operation{}
+
+		for method, operation := range operations {
+			alias := *operation
+			alias.Deprecated = true
+			spec.Paths[path][method] = &alias
+		}
+	}
+
+	return spec
+}
+
+// Function takes in properties and returns a reference to a Schema of an object.
+func object(properties map[string]*Schema) *Schema {
+	return &Schema{Type: []string{"object"}, Properties: properties}
+}
+
+// Function takes in a name and a Schema and returns a required path Parameter.
+func pathParameter(name string, schema *Schema) Parameter {
+	return Parameter{Name: name, In: "path", Required: true, Schema: schema}
+}
+
+// Function takes in a Schema and returns a reference to a required json RequestBody.
+func jsonBody(schema *Schema) *RequestBody {
+	return &RequestBody{Required: true, Content: map[string]MediaType{"application/json": {Schema: schema}}}
+}
+
+// Function takes in a description and a Schema and returns a reference to a json Response.
+func jsonResponse(description string, schema *Schema) *Response {
+	return &Response{Description: description, Content: map[string]MediaType{"application/json": {Schema: schema}}}
+}
+
+// Function takes in a reference to a mux.Router and returns an error listing routes
+// that are missing from the OpenAPI document. Checked by openapi_test.go so a route
+// can't be added without documenting it.
+func checkSpec(router *mux.Router) error {
+
+	var missing []string
+
+	err := router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+
+		template, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+
+		// Routes without methods only group other routes.
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+
+		path := openapiPath(template)
+
+		for _, method := range methods {
+			if _, ok := api_spec.Paths[path][strings.ToLower(method)]; !ok {
+				missing = append(missing, method+" "+path)
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("routes missing from the OpenAPI document: %s", strings.Join(missing, ", "))
+	}
+
+	return nil
+}
+
+// Function takes in a mux path template and returns it with variables
+// written the OpenAPI way, e.g. /images/{hash:[0-9a-f]{64}} becomes /images/{hash}.
+func openapiPath(template string) string {
+
+	var (
+		builder strings.Builder
+		// Depth of braces, patterns of variables may contain braces too.
+		depth int
+		// Whether the variable's name was read and its pattern is skipped.
+		pattern bool
+	)
+
+	for _, r := range template {
+
+		switch {
+
+		case r == '{':
+			depth++
+			if depth == 1 {
+				pattern = false
+				builder.WriteRune(r)
+			}
+
+		case r == '}':
+			depth--
+			if depth == 0 {
+				builder.WriteRune(r)
+			}
+
+		case depth == 1 && r == ':':
+			pattern = true
+
+		case depth == 0 || !pattern:
+			builder.WriteRune(r)
+		}
+	}
+
+	return builder.String()
+}
+
+// Route handler to get the OpenAPI document of the server.
+// Function takes in the standart handler parameters http.ResponseWriter and a reference
+// to a http.Request and responds with the document.
+func (s *Server) getSpec(writer http.ResponseWriter, request *http.Request) {
+	respond(writer, http.StatusOK, api_spec)
+}
+
+// Route handler to get the interactive documentation of the API.
+// Function takes in the standart handler parameters http.ResponseWriter and a reference
+// to a http.Request and responds with the Swagger UI page.
+func (s *Server) getDocs(writer http.ResponseWriter, request *http.Request) {
+	writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+	writer.WriteHeader(http.StatusOK)
+	writer.Write([]byte(docs_page))
+}
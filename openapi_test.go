@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// Function returns a reference to a Server with the routes of createRoutes and an empty MemoryStore.
+func testServer() *Server {
+
+	s := &Server{store: newMemoryStore(), router: mux.NewRouter()}
+	s.createRoutes()
+
+	return s
+}
+
+// Every route registered in createRoutes has to be documented.
+func TestSpecDocumentsRoutes(t *testing.T) {
+
+	if err := checkSpec(testServer().router); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDocsPointToSpec(t *testing.T) {
+
+	s := testServer()
+	recorder := httptest.NewRecorder()
+	s.router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, v1_prefix+docs_path, nil))
+
+	if recorder.Code != http.StatusOK || !strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/html") {
+		t.Fatalf("docs responded with %d %s", recorder.Code, recorder.Header().Get("Content-Type"))
+	}
+
+	// The page loads the document relative to its own path.
+	if !strings.Contains(recorder.Body.String(), `url: "`+strings.TrimPrefix(openapi_path, "/")+`"`) {
+		t.Fatal("docs page doesn't load the OpenAPI document")
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math/bits"
+	"sort"
+	"strconv"
+
+	"github.com/nfnt/resize"
+)
+
+// Maximum number of differing bits for two hashes to be considered
+// the same image.
+const hash_match_threshold = 10
+
+// Function takes in an image.Image and returns its 64 bit difference hash.
+// Image is shrunk to 9x8 grayscale pixels and every bit represents whether
+// a pixel is brighter than its right neighbour, so hashes survive resizing,
+// recompression and small colour changes.
+func dhash(img image.Image) uint64 {
+
+	small := resize.Resize(9, 8, img, resize.Bilinear)
+	bounds := small.Bounds()
+
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			left := color.GrayModel.Convert(small.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.Gray).Y
+			right := color.GrayModel.Convert(small.At(bounds.Min.X+x+1, bounds.Min.Y+y)).(color.Gray).Y
+
+			hash <<= 1
+			if left > right {
+				hash |= 1
+			}
+		}
+	}
+
+	return hash
+}
+
+// Function takes in two hashes and returns the number of differing bits.
+func hashDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// Function takes in a hash and returns it as a 16 character hex string.
+func formatHash(hash uint64) string {
+	return fmt.Sprintf("%016x", hash)
+}
+
+// Function takes in a hex string and returns the hash it represents.
+func parseHash(str string) (uint64, error) {
+	return strconv.ParseUint(str, 16, 64)
+}
+
+// Function takes in a hash, id of the attraction it belongs to (may be empty)
+// and a slice of stored ImageHashes. Returns a slice of HashMatches of other
+// attractions' similar images, closest first.
+func findMatches(hash uint64, id string, hashes []ImageHash) []HashMatch {
+
+	matches := make([]HashMatch, 0)
+
+	for _, stored := range hashes {
+		if stored.id == id {
+			continue
+		}
+		if dist := hashDistance(hash, stored.hash); dist <= hash_match_threshold {
+			matches = append(matches, HashMatch{stored.id, stored.position, dist})
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool { return matches[i].Distance < matches[j].Distance })
+
+	return matches
+}
+
+// Function takes in an AttractionStore and a slice of processed Downloadables whose
+// hashes are already stored in it and returns a slice of warnings for attractions that
+// use an image similar to another attraction's.
+func checkDuplicates(store AttractionStore, downloadables []Downloadable) ([]string, error) {
+
+	// see db.go
+	stored, err := store.hashes()
+	if err != nil {
+		return nil, err
+	}
+
+	warnings := make([]string, 0)
+	// Pairs of images that were already reported.
+	reported := map[string]bool{}
+
+	for _, down := range downloadables {
+		for _, match := range findMatches(down.hash, down.id, stored) {
+
+			other := fmt.Sprintf("%s#%d", match.Id, match.Position)
+
+			pair := down.key() + "|" + other
+			if other < down.key() {
+				pair = other + "|" + down.key()
+			}
+			if reported[pair] {
+				continue
+			}
+			reported[pair] = true
+
+			warnings = append(warnings, fmt.Sprintf("%s: image similar to %s (distance %d)", down.key(), other, match.Distance))
+		}
+	}
+
+	return warnings, nil
+}
+
+type ImageHash struct {
+	id       string
+	position int
+	hash     uint64
+}
+
+// Attraction's image that is similar and the number of differing hash bits.
+type HashMatch struct {
+	Id       string `json:"id"`
+	Position int    `json:"position"`
+	Distance int    `json:"distance"`
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"strings"
+
+	"github.com/nfnt/resize"
+)
+
+// Number of BlurHash components horizontally and vertically.
+const (
+	blurhash_x = 4
+	blurhash_y = 3
+)
+
+const base83_chars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+// Function takes in a processed image.Image and returns its BlurHash
+// and average colour as a hex string (#rrggbb).
+func placeholder(img image.Image) (string, string) {
+
+	// Placeholders are blurry anyway so there is no need to go through every pixel.
+	small := resize.Resize(32, 0, img, resize.Bilinear)
+	bounds := small.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+
+	// Converting pixels to linear RGB once.
+	pixels := make([][3]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			r, g, b, _ := small.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			pixels[y*w+x] = [3]float64{toLinear(r >> 8), toLinear(g >> 8), toLinear(b >> 8)}
+		}
+	}
+
+	factors := make([][3]float64, 0, blurhash_x*blurhash_y)
+
+	for j := 0; j < blurhash_y; j++ {
+		for i := 0; i < blurhash_x; i++ {
+			factors = append(factors, blurhashFactor(pixels, w, h, i, j))
+		}
+	}
+
+	// First factor is the average colour of the image.
+	dc, ac := factors[0], factors[1:]
+
+	var hash strings.Builder
+
+	hash.WriteString(base83((blurhash_x-1)+(blurhash_y-1)*9, 1))
+
+	var max_value float64
+	for _, f := range ac {
+		for _, c := range f {
+			max_value = math.Max(max_value, math.Abs(c))
+		}
+	}
+
+	quantised_max := int(math.Max(0, math.Min(82, math.Floor(max_value*166-0.5))))
+	max_value = float64(quantised_max+1) / 166
+	hash.WriteString(base83(quantised_max, 1))
+
+	hash.WriteString(base83(toSRGB(dc[0])<<16+toSRGB(dc[1])<<8+toSRGB(dc[2]), 4))
+
+	for _, f := range ac {
+		value := 0
+		for _, c := range f {
+			q := int(math.Max(0, math.Min(18, math.Floor(signPow(c/max_value, 0.5)*9+9.5))))
+			value = value*19 + q
+		}
+		hash.WriteString(base83(value, 2))
+	}
+
+	colour := fmt.Sprintf("#%02x%02x%02x", toSRGB(dc[0]), toSRGB(dc[1]), toSRGB(dc[2]))
+
+	return hash.String(), colour
+}
+
+// Function takes in linear pixels of an image, its dimensions and component
+// indices and returns the cosine transform factor for every channel.
+func blurhashFactor(pixels [][3]float64, w, h, i, j int) [3]float64 {
+
+	var factor [3]float64
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			basis := math.Cos(math.Pi*float64(i)*float64(x)/float64(w)) * math.Cos(math.Pi*float64(j)*float64(y)/float64(h))
+			for c := range factor {
+				factor[c] += basis * pixels[y*w+x][c]
+			}
+		}
+	}
+
+	normalisation := 2.0
+	if i == 0 && j == 0 {
+		normalisation = 1
+	}
+
+	scale := normalisation / float64(w*h)
+	for c := range factor {
+		factor[c] *= scale
+	}
+
+	return factor
+}
+
+// Function takes in an 8 bit sRGB value and returns it in linear space.
+func toLinear(value uint32) float64 {
+	v := float64(value) / 255
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+// Function takes in a linear value and returns it as an 8 bit sRGB value.
+func toSRGB(value float64) int {
+	v := math.Max(0, math.Min(1, value))
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+// Function raises the absolute value to a power and keeps the sign.
+func signPow(value, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(value), exp), value)
+}
+
+// Function takes in a value and encodes it as a base 83 string of given length.
+func base83(value, length int) string {
+	result := make([]byte, length)
+	for i := length - 1; i >= 0; i-- {
+		result[i] = base83_chars[value%83]
+		value /= 83
+	}
+	return string(result)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+// Function takes in dimensions and a colour and returns an image filled with the colour.
+func solidImage(w, h int, c color.RGBA) *image.RGBA {
+
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestPlaceholderOfSolidImage(t *testing.T) {
+
+	hash, colour := placeholder(solidImage(90, 60, color.RGBA{0x33, 0x66, 0x99, 0xff}))
+
+	if colour != "#336699" {
+		t.Errorf("colour is %s, want #336699", colour)
+	}
+
+	// Size flag, maximum AC value, average colour and 11 AC components of 2 characters.
+	if len(hash) != 1+1+4+(blurhash_x*blurhash_y-1)*2 {
+		t.Fatalf("blurhash %q has %d characters", hash, len(hash))
+	}
+	if hash[:1] != base83((blurhash_x-1)+(blurhash_y-1)*9, 1) {
+		t.Errorf("blurhash %q doesn't start with the size flag", hash)
+	}
+	if hash[2:6] != base83(0x336699, 4) {
+		t.Errorf("blurhash %q doesn't contain the average colour", hash)
+	}
+
+	// Components of a solid image only have the error of the sampled cosines.
+	if largest := strings.IndexByte(base83_chars, hash[1]); largest > 8 {
+		t.Errorf("blurhash %q of a solid image has components up to %d", hash, largest)
+	}
+}
+
+func TestPlaceholderOfGradient(t *testing.T) {
+
+	img := image.NewRGBA(image.Rect(0, 0, 90, 60))
+	for y := 0; y < 60; y++ {
+		for x := 0; x < 90; x++ {
+			img.SetRGBA(x, y, color.RGBA{uint8(x * 255 / 89), 0, 0, 0xff})
+		}
+	}
+
+	hash, _ := placeholder(img)
+	solid, _ := placeholder(solidImage(90, 60, color.RGBA{0x80, 0, 0, 0xff}))
+
+	if strings.IndexByte(base83_chars, hash[1]) <= strings.IndexByte(base83_chars, solid[1]) {
+		t.Fatalf("gradient's components aren't larger than a solid image's: %q, %q", hash, solid)
+	}
+}
+
+func TestBase83(t *testing.T) {
+
+	for _, test := range []struct {
+		value, length int
+		expected      string
+	}{
+		{0, 1, "0"},
+		{21, 1, "L"},
+		{82, 1, "~"},
+		{83, 2, "10"},
+		{83*83 - 1, 2, "~~"},
+	} {
+		if encoded := base83(test.value, test.length); encoded != test.expected {
+			t.Errorf("base83(%d, %d) = %q, want %q", test.value, test.length, encoded, test.expected)
+		}
+	}
+}
+
+// Every 8 bit value survives the conversion to linear space and back.
+func TestLinearRoundTrip(t *testing.T) {
+
+	for value := uint32(0); value < 256; value++ {
+		if back := toSRGB(toLinear(value)); back != int(value) {
+			t.Fatalf("%d became %d", value, back)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"net/http"
+)
+
+// Function takes in a decoded source image.Image and returns a reference to an ApiError
+// with the quality rule it doesn't meet or nil if it meets all of them.
+func checkSource(img image.Image) *ApiError {
+
+	rules := config.Quality
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+
+	if w < rules.MinWidth || h < rules.MinHeight {
+		return apiError(http.StatusUnprocessableEntity, code_image_too_small, w, h, rules.MinWidth, rules.MinHeight)
+	}
+
+	// Images are resized to image_width, upscaling too much only produces mush.
+	if upscale := float64(image_width) / float64(w); upscale > rules.MaxUpscale {
+		return apiError(http.StatusUnprocessableEntity, code_image_upscaled, upscale, rules.MaxUpscale)
+	}
+
+	return nil
+}
+
+// Function takes in a processed image.Image and returns a reference to an ApiError
+// if it is too blurry or nil if it's sharp enough.
+func checkSharpness(img image.Image) *ApiError {
+
+	if score := sharpness(img); score < config.Quality.MinSharpness {
+		return apiError(http.StatusUnprocessableEntity, code_image_too_blurry, score, config.Quality.MinSharpness)
+	}
+
+	return nil
+}
+
+// Function takes in an image.Image and returns the variance of its Laplacian.
+// Blurry images have few edges so the variance is low.
+func sharpness(img image.Image) float64 {
+
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+
+	if w < 3 || h < 3 {
+		return 0
+	}
+
+	// Converting the image to grayscale.
+	gray := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			gray[y*w+x] = float64(color.GrayModel.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.Gray).Y)
+		}
+	}
+
+	var sum, sum_sq float64
+	n := float64((w - 2) * (h - 2))
+
+	// Applying the 4-neighbour Laplacian kernel to every inner pixel.
+	for y := 1; y < h-1; y++ {
+		for x := 1; x < w-1; x++ {
+			i := y*w + x
+			lap := gray[i-w] + gray[i+w] + gray[i-1] + gray[i+1] - 4*gray[i]
+			sum += lap
+			sum_sq += lap * lap
+		}
+	}
+
+	mean := sum / n
+
+	return sum_sq/n - mean*mean
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// Function takes in dimensions and returns an image of black and white squares of 4 pixels.
+func checkerboard(w, h int) *image.Gray {
+
+	img := image.NewGray(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if (x/4+y/4)%2 == 0 {
+				img.SetGray(x, y, color.Gray{255})
+			}
+		}
+	}
+	return img
+}
+
+// Function takes in an image and returns it blurred by averaging every pixel with its neighbours.
+func boxBlur(img *image.Gray, radius int) *image.Gray {
+
+	bounds := img.Bounds()
+	blurred := image.NewGray(bounds)
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			sum, n := 0, 0
+			for dy := -radius; dy <= radius; dy++ {
+				for dx := -radius; dx <= radius; dx++ {
+					if (image.Point{x + dx, y + dy}).In(bounds) {
+						sum += int(img.GrayAt(x+dx, y+dy).Y)
+						n++
+					}
+				}
+			}
+			blurred.SetGray(x, y, color.Gray{uint8(sum / n)})
+		}
+	}
+	return blurred
+}
+
+func TestCheckSource(t *testing.T) {
+
+	// Defaults: at least 800x533, upscaled to image_width by at most 1.5.
+	for _, test := range []struct {
+		w, h int
+		code string
+	}{
+		{1200, 800, ""},
+		{800, 533, ""},
+		{2400, 1600, ""},
+		{799, 600, code_image_too_small},
+		{1000, 532, code_image_too_small},
+		{100, 100, code_image_too_small},
+	} {
+		rejected := checkSource(image.NewGray(image.Rect(0, 0, test.w, test.h)))
+
+		code := ""
+		if rejected != nil {
+			code = rejected.code
+		}
+		if code != test.code {
+			t.Errorf("%dx%d was rejected with %q, want %q", test.w, test.h, code, test.code)
+		}
+	}
+
+	previous := config.Quality
+	defer func() { config.Quality = previous }()
+
+	// 800 pixels wide images are upscaled by 1.5, 600 by 2.
+	config.Quality.MinWidth, config.Quality.MinHeight = 100, 100
+
+	if rejected := checkSource(image.NewGray(image.Rect(0, 0, 800, 600))); rejected != nil {
+		t.Errorf("upscaling by 1.5 was rejected with %v", rejected)
+	}
+	if rejected := checkSource(image.NewGray(image.Rect(0, 0, 600, 600))); rejected == nil || rejected.code != code_image_upscaled {
+		t.Errorf("upscaling by 2 was rejected with %v", rejected)
+	}
+}
+
+func TestCheckSharpness(t *testing.T) {
+
+	sharp := checkerboard(120, 80)
+	blurred := boxBlur(sharp, 4)
+
+	if sharpness(sharp) <= sharpness(blurred) {
+		t.Fatalf("sharp image scores %.1f, blurred %.1f", sharpness(sharp), sharpness(blurred))
+	}
+
+	if rejected := checkSharpness(sharp); rejected != nil {
+		t.Errorf("sharp image was rejected with %v", rejected)
+	}
+	if rejected := checkSharpness(blurred); rejected == nil || rejected.code != code_image_too_blurry {
+		t.Errorf("blurred image was rejected with %v", rejected)
+	}
+	if rejected := checkSharpness(image.NewGray(image.Rect(0, 0, 120, 80))); rejected == nil {
+		t.Error("blank image wasn't rejected")
+	}
+	if score := sharpness(image.NewGray(image.Rect(0, 0, 2, 2))); score != 0 {
+		t.Errorf("image too small for the kernel scores %.1f", score)
+	}
+}
@@ -2,43 +2,97 @@ package main
 
 import (
 	"bytes"
-	"encoding/base64"
-	"encoding/json"
 	"fmt"
 	"image"
-	"image/jpeg"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
-	"os"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/nfnt/resize"
 	"github.com/oliamb/cutter"
 )
 
-// Function takes in a command split by spaces, merges cache with an external database,
-// downloads and processes images and either saves them locally or posts them to the provided
-// url in json format.
-func merge(parts []string) string {
+// Width in pixels processed images are resized to.
+const image_width = 1200
 
-	attractions, err := readCache()
+// Maximum size of a downloaded image in bytes.
+const max_download_size = 25 << 20
+
+// Client images are downloaded with. Image urls come from users, so only public
+// addresses are dialed and downloads can't take longer than the timeout.
+var image_client = &http.Client{
+	Timeout: 30 * time.Second,
+	Transport: &http.Transport{
+		DialContext:         (&net.Dialer{Timeout: 10 * time.Second, Control: dialPublic}).DialContext,
+		TLSHandshakeTimeout: 10 * time.Second,
+	},
+}
+
+// Function takes in a command split by spaces and an AttractionStore, merges attractions that
+// were added or changed since the last merge with an external database, downloads and processes
+// their images and either saves them locally or posts them to the provided url in json format.
+func merge(parts []string, store AttractionStore) string {
+
+	// Receiver can't tell our uploads from forged ones without a signature.
+	if len(parts) > 2 && config.Send.Secret == "" {
+		return "Failed to merge: no send.secret configured to sign images with"
+	}
+
+	// see db.go
+	attractions, err := readPending(store)
 	if err != nil {
 		return fmt.Sprintf("Failed to merge: %s", err.Error())
 	}
 
-	// Committing attractions to an external DB
-	if err := commitAttractionsToDB(parts[1], attractions); err != nil {
+	started := time.Now().UTC()
+	// Id of the merge run, also used as the merge batch id, see history.go
+	batch := runID(started)
+
+	// Committing attractions to an external DB. The run is recorded as pending before the
+	// target commits so its rows can be rolled back whatever happens next, see history.go
+	// The target is recorded without credentials, see target.go
+	result, err := commitAttractionsToDB(parts[1], attractions, func(result *MergeResult) error {
+		return store.recordRun(batch, started, redactTarget(parts[1]), result)
+	})
+	if err != nil {
+		// The run was recorded if the target failed to commit.
+		if result != nil {
+			store.finishRun(batch, run_failed)
+		}
 		return fmt.Sprintf("Failed to merge: %s", err.Error())
 	}
 
+	summary := fmt.Sprintf("Merged batch %s: %s.\n", batch, result)
+
+	if err := store.finishRun(batch, run_committed); err != nil {
+		summary += fmt.Sprintf("Failed to mark the merge run as committed: %s\n", err.Error())
+	}
+
 	var (
 		// Slice that contains images yet to download.
 		toDownload []Downloadable
-		// Slice that contains ids of attractions that either failed or doens't have an url.
-		failed []string
+		// Slice that contains images that failed and attractions that don't have any.
+		failed []Failure
 	)
 
-	// Extracting ids and urls from attractions.
-	getUrls(attractions, &toDownload, &failed)
+	// Every exit from here on records images that failed in the run.
+	report := func(text string) string {
+		if err := store.recordFailures(batch, failed); err != nil {
+			summary += fmt.Sprintf("Failed to record failed images: %s\n", err.Error())
+		}
+		return summary + text
+	}
+
+	images := newImageStore(config.Store.Root)
+
+	// Extracting ids and urls of gallery images from attractions.
+	getUrls(attractions, images, &toDownload, &failed)
 
 	// Downloading images.
 	download(&toDownload, &failed)
@@ -46,138 +100,416 @@ func merge(parts []string) string {
 	// Resizing & cropping images to fit required dimensions.
 	process(&toDownload, &failed)
 
+	// Comparing where the photos were taken with attractions' locations, see exif.go
+	warnings := checkLocations(toDownload)
+
+	// Storing image hashes and placeholders, see gallery.go
+	if err := store.storeProcessed(toDownload); err != nil {
+		return report(fmt.Sprintf("Failed to store processed images: %s", err.Error()))
+	}
+
+	// Looking for images reused across attractions, see phash.go
+	duplicates, err := checkDuplicates(store, toDownload)
+	if err != nil {
+		return report(fmt.Sprintf("Failed to check duplicate images: %s", err.Error()))
+	}
+	warnings = append(warnings, duplicates...)
+
 	// If provided, images will be send to an url.
 	if len(parts) > 2 {
-		send(toDownload, parts[2])
-		return fmt.Sprintf("Sent %d images to %s.\nFinished with %d failed images\n\t%v",
-			len(toDownload), parts[2], len(failed), failed)
+		// see send.go
+		sent := send(toDownload, parts[2], &failed)
+		summary += markCompleted(store, attractions, failed, batch)
+		return report(fmt.Sprintf("Sent %d images to %s.\nFinished with %d failed images\n\t%s\n%d warnings\n\t%s",
+			sent, parts[2], len(failed), formatFailures(failed), len(warnings), strings.Join(warnings, "\n\t")))
+	}
+
+	// If no url provided images will be saved to the local image store.
+	saved := save(toDownload, images, &failed)
+
+	// see gallery.go
+	if err := store.storeSaved(toDownload, saved); err != nil {
+		return report(fmt.Sprintf("Failed to record saved images: %s", err.Error()))
 	}
 
-	// If no url provided images will be saved locally.
-	save(toDownload, &failed)
-	return fmt.Sprintf("Finished with %d failed images\n\t%v", len(failed), failed)
+	summary += markCompleted(store, attractions, failed, batch)
+
+	return report(fmt.Sprintf("Saved %d images to %s.\nFinished with %d failed images\n\t%s\n%d warnings\n\t%s",
+		len(saved), config.Store.Root, len(failed), formatFailures(failed), len(warnings), strings.Join(warnings, "\n\t")))
+}
+
+// Function takes in an AttractionStore, merged attractions, Failures of the merge run and its id.
+// Attractions whose images all succeeded are marked as merged so the next merge skips them
+// unless they change, the rest stay pending so their images are retried. Attractions without
+// images have nothing to retry. Returns a line of the report if marking failed.
+func markCompleted(store AttractionStore, attractions []Attraction, failed []Failure, batch string) string {
+
+	retried := map[string]bool{}
+	for _, f := range failed {
+		if f.position != no_position {
+			retried[f.id] = true
+		}
+	}
+
+	completed := make([]Attraction, 0, len(attractions))
+	for _, attr := range attractions {
+		if !retried[attr.id] {
+			completed = append(completed, attr)
+		}
+	}
+
+	if err := store.markMerged(completed, batch); err != nil {
+		return fmt.Sprintf("Failed to mark attractions as merged: %s\n", err.Error())
+	}
+
+	if pending := len(attractions) - len(completed); pending > 0 {
+		return fmt.Sprintf("%d attractions with failed images stay pending and are merged again.\n", pending)
+	}
+
+	return ""
 }
 
 // Function takes in a reference to a slice of Downloadables and
-// a reference to slice of ids that failed to download. Bytes are downloaded
+// a reference to slice of Failures. Bytes are downloaded
 // from an image url and put into the downloadable.
-func download(toDownload *[]Downloadable, failed *[]string) {
+func download(toDownload *[]Downloadable, failed *[]Failure) {
 	// Creating a new slice with the same underlying slice in order to
 	// leave out downloadables that failed to download.
 	new_down := (*toDownload)[:0]
 	for _, down := range *toDownload {
 
+		// Uploaded images are already present.
+		if down.image != nil {
+			new_down = append(new_down, down)
+			continue
+		}
+
 		// Downloading image bytes.
-		response, err := http.Get(down.url)
+		data, err := fetchImage(down.url)
 
 		if err != nil {
-			*failed = append(*failed, down.id)
+			*failed = append(*failed, down.failure("download failed: "+err.Error()))
 			continue
-		} else {
-			// Assigning bytes to the downloadable.
-			down.image, err = ioutil.ReadAll(response.Body)
-			new_down = append(new_down, down)
 		}
-		response.Body.Close()
+
+		// Assigning bytes to the downloadable.
+		down.image = data
+		new_down = append(new_down, down)
 	}
 	*toDownload = new_down
 }
 
+// Function takes in an image url and returns downloaded bytes
+// and an error if it occurs. Only http and https urls are downloaded.
+func fetchImage(address string) ([]byte, error) {
+
+	parsed, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return nil, fmt.Errorf("Image url must be http or https, got %q", parsed.Scheme)
+	}
+
+	response, err := image_client.Get(address)
+	if err != nil {
+		return nil, err
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Image url responded with %s", response.Status)
+	}
+
+	// Reading a byte more than allowed to tell whether the image is too large.
+	data, err := ioutil.ReadAll(io.LimitReader(response.Body, max_download_size+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) > max_download_size {
+		return nil, fmt.Errorf("Image is larger than %d bytes", max_download_size)
+	}
+
+	return data, nil
+}
+
+// Special-purpose ranges (IANA registries, RFC 6890) that are unicast but not reachable on
+// the internet or lead into other networks, e.g. shared CGNAT addresses or NAT64 and 6to4
+// addresses that embed IPv4 addresses.
+var non_public_prefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("192.0.2.0/24"),
+	netip.MustParsePrefix("192.88.99.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("198.51.100.0/24"),
+	netip.MustParsePrefix("203.0.113.0/24"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+	netip.MustParsePrefix("64:ff9b:1::/48"),
+	netip.MustParsePrefix("100::/64"),
+	netip.MustParsePrefix("2001::/23"),
+	netip.MustParsePrefix("2001:db8::/32"),
+	netip.MustParsePrefix("2002::/16"),
+}
+
+// Function takes in a network, an address being dialed and the raw connection and returns an
+// error if the address isn't public. Used as the dialer's Control so addresses are checked
+// after the host is resolved, including hosts of redirects.
+func dialPublic(network, address string, conn syscall.RawConn) error {
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	if ip, err := netip.ParseAddr(host); err != nil || !isPublic(ip) {
+		return fmt.Errorf("Image address %s is not public", host)
+	}
+
+	return nil
+}
+
+// Function takes in an IP address and returns whether it is a global unicast address
+// outside of private and special-purpose ranges. IPv4-mapped IPv6 addresses are checked
+// as IPv4 addresses.
+func isPublic(ip netip.Addr) bool {
+
+	ip = ip.Unmap()
+
+	// Loopback, link-local, multicast and unspecified addresses aren't global unicast.
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() {
+		return false
+	}
+
+	for _, prefix := range non_public_prefixes {
+		if prefix.Contains(ip) {
+			return false
+		}
+	}
+
+	return true
+}
+
 // Function takes in a reference to a slice of Downloadables and
-// a reference to slice of ids that failed to download. new image.Image
-// object is processed and assigned to the downloadable.
-func process(toDownload *[]Downloadable, failed *[]string) {
+// a reference to slice of Failures. new image.Image object is processed and
+// assigned to the downloadable. Images that don't meet the quality rules are
+// rejected, see quality.go. Attribution is rendered over images whose licence
+// is configured in config.Watermark.
+func process(toDownload *[]Downloadable, failed *[]Failure) {
 	// Creating a new slice with the same underlying slice in order to
 	// leave out downloadables that failed to process.
 	new_down := (*toDownload)[:0]
 
 	for _, down := range *toDownload {
 
-		// Creating image.Image from bytes.
-		img, _, err := image.Decode(bytes.NewReader(down.image))
-
-		if err != nil {
-			*failed = append(*failed, down.id)
+		if down.encoded != nil {
+			// Processed uploads are only decoded so they can be watermarked.
+			img, _, err := image.Decode(bytes.NewReader(down.encoded))
+			if err != nil {
+				*failed = append(*failed, down.failure("decode failed: "+err.Error()))
+				continue
+			}
+			down.decoded_img = img
+		} else if rejected := processImage(&down); rejected != nil {
+			*failed = append(*failed, down.failure(rejected.Error()))
 			continue
 		}
 
-		// Resizing the image to be 1200 pixels width.
-		img = resize.Resize(1200, 0, img, resize.Bicubic)
-		// Cropping the image to fit  3 by 2 aspect ration.
-		img, err = cutter.Crop(img, cutter.Config{Width: 3, Height: 2, Mode: cutter.Centered, Options: cutter.Ratio})
-
-		if err != nil {
-			*failed = append(*failed, down.id)
+		// Rendering the attribution over the image, see watermark.go
+		if err := applyWatermark(&down); err != nil {
+			*failed = append(*failed, down.failure("watermark failed: "+err.Error()))
 			continue
 		}
-		// Assigning image.Image to the downloadable.
-		down.decoded_img = img
+
 		new_down = append(new_down, down)
 	}
 	*toDownload = new_down
 }
 
-// Function takes in a slice of attractions, a reference to a slice of Downloadables and
-// a reference to slice of ids that failed to download. Attraction's id and url is added to a
-// toDownload slice is url is present, otherwise id is added to the failed slice.
-func getUrls(attractions []Attraction, toDownload *[]Downloadable, failed *[]string) {
+// Function takes in a reference to a Downloadable with image bytes, decodes,
+// rotates, resizes and crops the image and assigns the result and its metadata
+// to the downloadable. Returns a reference to an ApiError with the reason the image
+// was rejected or nil if it was processed.
+func processImage(down *Downloadable) *ApiError {
+
+	// Creating image.Image from bytes.
+	img, _, err := image.Decode(bytes.NewReader(down.image))
+
+	if err != nil {
+		return apiError(http.StatusUnprocessableEntity, code_image_decode_failed)
+	}
+
+	// Reading EXIF metadata and rotating the image upright before resizing, see exif.go
+	orientation, gps := readExif(down.image)
+	img = orient(img, orientation)
+	down.gps = gps
+	// Perceptual hash used to detect reused images, see phash.go
+	down.hash = dhash(img)
+
+	if rejected := checkSource(img); rejected != nil {
+		return rejected
+	}
+
+	// Resizing the image to be image_width pixels width.
+	img = resize.Resize(image_width, 0, img, resize.Bicubic)
+	// Cropping the image to fit  3 by 2 aspect ration.
+	img, err = cutter.Crop(img, cutter.Config{Width: 3, Height: 2, Mode: cutter.Centered, Options: cutter.Ratio})
+
+	if err != nil {
+		return apiError(http.StatusUnprocessableEntity, code_image_crop_failed)
+	}
+
+	if rejected := checkSharpness(img); rejected != nil {
+		return rejected
+	}
+
+	// Placeholders shown by the frontend while the image loads, see placeholder.go
+	down.blurhash, down.colour = placeholder(img)
+
+	// Assigning image.Image to the downloadable.
+	down.decoded_img = img
+
+	return nil
+}
+
+// Function takes in a slice of attractions, a reference to the ImageStore uploads are kept in,
+// a reference to a slice of Downloadables and a reference to slice of Failures. Every gallery
+// image is added to the toDownload slice, uploaded images are read from the store, others are
+// downloaded from their url. Attractions without images are added to the failed slice.
+func getUrls(attractions []Attraction, store *ImageStore, toDownload *[]Downloadable, failed *[]Failure) {
 	for _, attr := range attractions {
-		if attr.url.Valid {
-			*toDownload = append(*toDownload, Downloadable{url: attr.url.String, id: attr.id})
-		} else {
-			*failed = append(*failed, attr.id)
+
+		if len(attr.images) == 0 {
+			*failed = append(*failed, Failure{attr.id, no_position, "no images"})
+			continue
+		}
+
+		for _, img := range attr.images {
+
+			down := Downloadable{id: attr.id, position: img.position, info: img, location: attr.coordinates()}
+
+			switch {
+			case img.upload.Valid:
+				data, err := store.get(img.upload.String)
+				if err != nil {
+					*failed = append(*failed, down.failure("reading upload failed: "+err.Error()))
+					continue
+				}
+				down.image = data
+				// Uploads were processed when they were uploaded, see upload.go
+				if hash, err := parseHash(img.phash.String); img.phash.Valid && err == nil {
+					down.encoded, down.hash = data, hash
+					down.blurhash, down.colour = img.blurhash.String, img.colour.String
+				}
+			case img.url.Valid:
+				down.url = img.url.String
+			default:
+				*failed = append(*failed, down.failure("no image url"))
+				continue
+			}
+
+			*toDownload = append(*toDownload, down)
 		}
 	}
 }
 
-// Function takes in a reference to a slice of Downloadables and
-// a reference to slice of ids that failed to download. The image is saved locally as
-// a jpeg with compression 80 or added as a failed id.
-func save(downloadables []Downloadable, failed *[]string) {
-	options := jpeg.Options{Quality: 80}
+// Function takes in a slice of Downloadables, a reference to an ImageStore
+// and a reference to slice of Failures. The image is encoded as a jpeg with
+// compression 80 and stored in the image store or added as a failed id.
+// Re-encoding the image leaves out all of the source metadata (GPS, camera, etc.).
+// Returns a map of saved images' keys to hashes.
+func save(downloadables []Downloadable, store *ImageStore, failed *[]Failure) map[string]string {
+
+	saved := map[string]string{}
+
 	for _, down := range downloadables {
 
-		// Creating the file.
-		file, err := os.Create(fmt.Sprintf("%s.jpg", down.id))
+		// see send.go
+		data, err := encodeJpeg(down)
 		if err != nil {
-			*failed = append(*failed, down.id)
+			*failed = append(*failed, down.failure("encode failed: "+err.Error()))
 			continue
 		}
 
-		defer file.Close()
-		// Encoding the image to the file created.
-		if err := jpeg.Encode(file, down.decoded_img, &options); err != nil {
-			*failed = append(*failed, down.id)
+		// see imagestore.go
+		hash, err := store.put(data)
+		if err != nil {
+			*failed = append(*failed, down.failure("save failed: "+err.Error()))
+			continue
 		}
+
+		saved[down.key()] = hash
 	}
+
+	return saved
 }
 
-// Function takes in a slice of Downloadables and
-// a url string to send the images to. Images are encoded as a
-// base64 string.
-func send(downloadables []Downloadable, url string) {
-	json_data := make([]map[string]string, 0)
+// Position of failures that concern the whole attraction rather than one of its images.
+const no_position = -1
 
-	for _, down := range downloadables {
-		json_data = append(json_data, map[string]string{
-			"id":    down.id,
-			"image": base64.StdEncoding.EncodeToString(down.image),
-		})
+// Image that couldn't be retrieved, or attraction without images, and the reason why.
+type Failure struct {
+	id string
+	// Position of the image in the attraction's gallery or no_position.
+	position int
+	reason   string
+}
+
+// Function returns the attraction's id or the key of the image, see Downloadable.key
+func (f Failure) key() string {
+	if f.position == no_position {
+		return f.id
 	}
+	return fmt.Sprintf("%s#%d", f.id, f.position)
+}
 
-	// Marshalling into json array of json objects {id string, image string}.
-	json, _ := json.Marshal(json_data)
+func (f Failure) String() string {
+	return fmt.Sprintf("%s: %s", f.key(), f.reason)
+}
 
-	// Posting the json to the url.
-	if _, err := http.Post(url, "application/json", bytes.NewBuffer(json)); err != nil {
-		fmt.Println("Failed to send images: ", err.Error())
+// Function takes in a slice of Failures and returns them as a string
+// with one failure per line.
+func formatFailures(failed []Failure) string {
+	lines := make([]string, 0, len(failed))
+	for _, f := range failed {
+		lines = append(lines, f.String())
 	}
+	return strings.Join(lines, "\n\t")
+}
+
+// Function returns a key that identifies the image, "<attraction id>#<position>".
+func (d *Downloadable) key() string {
+	return fmt.Sprintf("%s#%d", d.id, d.position)
+}
 
+// Function takes in a reason and returns a Failure of the image.
+func (d *Downloadable) failure(reason string) Failure {
+	return Failure{d.id, d.position, reason}
 }
 
 type Downloadable struct {
 	url         string
 	id          string
+	position    int
 	image       []byte
 	decoded_img image.Image
+	// Coordinates the photo was taken at, read from EXIF.
+	gps *Coordinates
+	// Coordinates of the attraction.
+	location Coordinates
+	// Perceptual hash of the image.
+	hash uint64
+	// BlurHash and average colour of the processed image.
+	blurhash string
+	colour   string
+	// Caption, author, licence of the gallery image.
+	info GalleryImage
+	// Jpeg of an image that was processed when it was uploaded, sent and saved
+	// as is rather than encoded again unless it's watermarked.
+	encoded []byte
 }
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestIsPublic(t *testing.T) {
+
+	for _, test := range []struct {
+		address string
+		public  bool
+	}{
+		{"93.184.215.14", true},
+		{"2606:2800:21f:cb07:6820:80da:af6b:8b2c", true},
+		{"127.0.0.1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"100.64.0.1", false},
+		{"100.127.255.254", false},
+		{"0.0.0.0", false},
+		{"198.18.0.1", false},
+		{"255.255.255.255", false},
+		{"224.0.0.1", false},
+		{"::1", false},
+		{"::", false},
+		{"fe80::1", false},
+		{"fd00::1", false},
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:100.64.0.1", false},
+		{"64:ff9b::a00:1", false},
+		{"2002:a00:1::", false},
+	} {
+		if public := isPublic(netip.MustParseAddr(test.address)); public != test.public {
+			t.Errorf("isPublic(%s) = %v, want %v", test.address, public, test.public)
+		}
+	}
+}
+
+func TestDialPublicRefusesHostnames(t *testing.T) {
+
+	// Addresses are checked after resolving, an unresolved name is refused.
+	if err := dialPublic("tcp", "localhost:80", nil); err == nil {
+		t.Fatal("dialing a hostname was allowed")
+	}
+	if err := dialPublic("tcp", "100.64.0.1:80", nil); err == nil {
+		t.Fatal("dialing a shared address was allowed")
+	}
+}
+
+// Attractions whose ids contain # are told apart from the keys of their images.
+func TestMarkCompleted(t *testing.T) {
+
+	store := newMemoryStore()
+
+	attractions := []Attraction{testAttraction("a#1"), testAttraction("a"), testAttraction("b")}
+	for ind := range attractions {
+		if err := store.add(&attractions[ind]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	failed := []Failure{{"a#1", 0, "download failed"}, {"b", no_position, "no images"}}
+	if out := markCompleted(store, attractions, failed, "run"); out == "" {
+		t.Fatal("markCompleted didn't report the pending attraction")
+	}
+
+	merged, err := store.merged()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := merged["a#1"]; ok || len(merged) != 2 || merged["a"] == "" || merged["b"] == "" {
+		t.Fatalf("merged attractions are %v, want a and b", merged)
+	}
+
+	if key := failed[0].key(); key != "a#1#0" {
+		t.Fatalf("key is %q", key)
+	}
+}
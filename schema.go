@@ -0,0 +1,373 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Path the attraction schema is served at, relative to the version's prefix.
+const schema_path = "/schema/attraction.json"
+
+// JSON Schema of the attraction payload accepted by /add, generated from RawAttraction.
+var attraction_schema = attractionSchema()
+
+// JSON Schema (draft 2020-12) of a value. Only keywords the attraction payload needs are supported.
+type Schema struct {
+	Schema               string             `json:"$schema,omitempty"`
+	Id                   string             `json:"$id,omitempty"`
+	Ref                  string             `json:"$ref,omitempty"`
+	Title                string             `json:"title,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Type                 []string           `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	OneOf                []*Schema          `json:"oneOf,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *bool              `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	MaxItems             *int               `json:"maxItems,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+
+	pattern *regexp.Regexp
+	// Code and values of the message reported when the value breaks
+	// its rules, see validation.go
+	code string
+	args []interface{}
+}
+
+// Function returns the schema of RawAttraction with the rules RawAttraction.validate checks.
+// Rules use the same constants as the validation so both stay in sync, rules that depend on
+// several values (image and images, authors of licences, a single primary image) are only
+// checked by the validation.
+func attractionSchema() *Schema {
+
+	s := schemaOf(reflect.TypeOf(RawAttraction{}))
+	s.Schema = "https://json-schema.org/draft/2020-12/schema"
+	s.Id = v1_prefix + schema_path
+	s.Title = "Attraction"
+
+	s.require("category", "description", "location")
+	s.at("description").require("name", "hours", "info")
+	s.at("description.hours").require("wkd", "std", "snd")
+	s.at("location").require("city", "coordinates")
+	s.at("location.coordinates").require("latitude", "longitude")
+	s.at("images[]").require("url", "licence")
+
+	category := s.at("category").rule(code_invalid_category, viable_categories)
+	category.Enum = viable_categories
+
+	s.at("description.name").rule(code_too_short, min_name_length).MinLength = intPointer(min_name_length + 1)
+	s.at("description.info").rule(code_too_short, min_info_length).MinLength = intPointer(min_info_length + 1)
+
+	for _, key := range []string{"wkd", "std", "snd"} {
+		s.at("description.hours." + key).rule(code_invalid_hours).match(regex_hours)
+	}
+
+	city := s.at("location.city").rule(code_invalid_city).match(regex_lith)
+	city.MinLength = intPointer(min_city_length + 1)
+
+	latitude := s.at("location.coordinates.latitude").rule(code_outside_lithuania)
+	latitude.Minimum, latitude.Maximum = floatPointer(min_latitude), floatPointer(max_latitude)
+
+	longitude := s.at("location.coordinates.longitude").rule(code_outside_lithuania)
+	longitude.Minimum, longitude.Maximum = floatPointer(min_longitude), floatPointer(max_longitude)
+
+	// Optional values may be null, the same as leaving them out.
+	for _, path := range []string{"image", "image.url", "image.copyright", "images", "images[].caption", "images[].author"} {
+		s.at(path).Type = append(s.at(path).Type, "null")
+	}
+
+	s.at("images").rule(code_gallery_too_large, max_gallery_size).MaxItems = intPointer(max_gallery_size)
+	s.at("images[].url").rule(code_invalid_url).match(regex_image_url)
+
+	licence := s.at("images[].licence").rule(code_invalid_licence, viable_licences)
+	licence.Enum = viable_licences
+
+	return s
+}
+
+// Function takes in a reflect.Type and returns a reference to a Schema of its json form.
+// Structs are objects without additional properties, properties are named by the fields'
+// json tags or their lowercase names. Pointers may be null, json.RawMessage is any value.
+func schemaOf(t reflect.Type) *Schema {
+
+	if t == reflect.TypeOf(json.RawMessage{}) {
+		return &Schema{}
+	}
+
+	switch t.Kind() {
+
+	case reflect.Struct:
+		s := &Schema{Type: []string{"object"}, Properties: map[string]*Schema{}, AdditionalProperties: new(bool)}
+		for ind := 0; ind < t.NumField(); ind++ {
+
+			field := t.Field(ind)
+
+			// Unexported fields aren't encoded.
+			if field.PkgPath != "" {
+				continue
+			}
+
+			name := strings.ToLower(field.Name)
+			if tag := strings.Split(field.Tag.Get("json"), ",")[0]; tag != "" {
+				name = tag
+			}
+
+			s.Properties[name] = schemaOf(field.Type)
+		}
+		return s
+
+	case reflect.Ptr:
+		s := schemaOf(t.Elem())
+		s.Type = append(s.Type, "null")
+		return s
+
+	case reflect.Slice:
+		return &Schema{Type: []string{"array"}, Items: schemaOf(t.Elem())}
+
+	case reflect.String:
+		return &Schema{Type: []string{"string"}}
+
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: []string{"number"}}
+
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		return &Schema{Type: []string{"integer"}}
+
+	case reflect.Bool:
+		return &Schema{Type: []string{"boolean"}}
+	}
+
+	return &Schema{}
+}
+
+// Function takes in a path of a value, e.g. description.hours.wkd or images[].url
+// where [] are the items of an array, and returns a reference to its Schema.
+// Panics if the path doesn't exist since the schema is built from constant paths.
+func (s *Schema) at(path string) *Schema {
+
+	current := s
+
+	for _, name := range strings.Split(path, ".") {
+
+		items := strings.HasSuffix(name, "[]")
+
+		current = current.Properties[strings.TrimSuffix(name, "[]")]
+		if current != nil && items {
+			current = current.Items
+		}
+
+		if current == nil {
+			panic("schema has no value " + path)
+		}
+	}
+
+	return current
+}
+
+// Function takes in a decoded json value and returns it with keys of objects renamed to the
+// schema's property they match case-insensitively, e.g. Category to category. Keys that match
+// exactly, or whose property is already in the object, are kept and checked as they are.
+func (s *Schema) foldKeys(value interface{}) interface{} {
+
+	switch v := value.(type) {
+
+	case map[string]interface{}:
+		folded := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			name := key
+			if _, ok := s.Properties[key]; !ok {
+				for property := range s.Properties {
+					if _, taken := v[property]; !taken && strings.EqualFold(key, property) {
+						name = property
+						break
+					}
+				}
+			}
+			if property, ok := s.Properties[name]; ok {
+				item = property.foldKeys(item)
+			}
+			folded[name] = item
+		}
+		return folded
+
+	case []interface{}:
+		if s.Items == nil {
+			return v
+		}
+		folded := make([]interface{}, len(v))
+		for ind, item := range v {
+			folded[ind] = s.Items.foldKeys(item)
+		}
+		return folded
+	}
+
+	return value
+}
+
+// Function takes in names of properties and marks them as required.
+func (s *Schema) require(names ...string) {
+	s.Required = append(s.Required, names...)
+}
+
+// Function takes in a validation code and values of its message reported
+// when the value breaks its rules. Returns the Schema.
+func (s *Schema) rule(code string, args ...interface{}) *Schema {
+	s.code, s.args = code, args
+	return s
+}
+
+// Function takes in a regexp.Regexp strings have to match. Returns the Schema.
+func (s *Schema) match(re *regexp.Regexp) *Schema {
+	s.pattern, s.Pattern = re, re.String()
+	return s
+}
+
+// Function takes in a decoded json value and returns ValidationErrors with every value
+// that doesn't match the schema. Property names are matched exactly, so keys that only
+// differ in case from a property are unknown rather than decoded by encoding/json.
+func (s *Schema) check(value interface{}) ValidationErrors {
+
+	var errs ValidationErrors
+	s.checkValue(value, "", &errs)
+
+	return errs
+}
+
+// Function takes in a decoded json value, its path and a reference to ValidationErrors
+// and adds an error for the value and every nested value that doesn't match the schema.
+func (s *Schema) checkValue(value interface{}, path string, errs *ValidationErrors) {
+
+	if !s.allows(value) {
+		errs.add(path, code_wrong_type, s.Type[0])
+		return
+	}
+
+	switch v := value.(type) {
+
+	case map[string]interface{}:
+
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				errs.add(joinPath(path, name), code_required)
+			}
+		}
+
+		// Sorted so errors are always reported in the same order.
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+
+			property, ok := s.Properties[key]
+
+			if !ok {
+				if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+					errs.add(joinPath(path, key), code_unknown_field, strconv.Quote(key))
+				}
+				continue
+			}
+
+			property.checkValue(v[key], joinPath(path, key), errs)
+		}
+
+	case []interface{}:
+
+		if s.MaxItems != nil && len(v) > *s.MaxItems {
+			errs.add(path, s.code, s.args...)
+		}
+
+		for ind, item := range v {
+			s.Items.checkValue(item, fmt.Sprintf("%s[%d]", path, ind), errs)
+		}
+
+	case string:
+
+		// Lengths are checked in bytes the same way as validate, see attraction.go. The document's
+		// minLength counts characters, so values it accepts are never too short for the server.
+		if (s.MinLength != nil && len(v) < *s.MinLength) ||
+			(s.pattern != nil && !s.pattern.MatchString(v)) ||
+			(s.Enum != nil && !sliceContains(&v, s.Enum)) {
+			errs.add(path, s.code, s.args...)
+		}
+
+	case float64:
+
+		if (s.Minimum != nil && v < *s.Minimum) || (s.Maximum != nil && v > *s.Maximum) {
+			errs.add(path, s.code, s.args...)
+		}
+	}
+}
+
+// Function takes in a decoded json value and returns whether its type is one of the schema's types.
+func (s *Schema) allows(value interface{}) bool {
+
+	if len(s.Type) == 0 {
+		return true
+	}
+
+	for _, t := range s.Type {
+
+		ok := false
+
+		switch v := value.(type) {
+		case nil:
+			ok = t == "null"
+		case map[string]interface{}:
+			ok = t == "object"
+		case []interface{}:
+			ok = t == "array"
+		case string:
+			ok = t == "string"
+		case bool:
+			ok = t == "boolean"
+		case float64:
+			ok = t == "number" || (t == "integer" && v == math.Trunc(v))
+		}
+
+		if ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Function takes in a path and a property name and returns the path of the property.
+func joinPath(path, name string) string {
+
+	if path == "" {
+		return name
+	}
+
+	return path + "." + name
+}
+
+func intPointer(value int) *int {
+	return &value
+}
+
+func floatPointer(value float64) *float64 {
+	return &value
+}
+
+// Route handler to get the JSON Schema of the attraction payload.
+// Function takes in the standart handler parameters http.ResponseWriter and a reference
+// to a http.Request and responds with the schema.
+func (s *Server) getSchema(writer http.ResponseWriter, request *http.Request) {
+	respond(writer, http.StatusOK, attraction_schema)
+}
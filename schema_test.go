@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// Function takes in a json body and returns the codes of its ValidationErrors by path.
+func schemaErrors(t *testing.T, body string) map[string]string {
+
+	var value interface{}
+	if err := json.Unmarshal([]byte(body), &value); err != nil {
+		t.Fatal(err)
+	}
+
+	codes := map[string]string{}
+	for _, err := range attraction_schema.check(value) {
+		codes[err.Path] = err.Code
+	}
+	return codes
+}
+
+func TestSchemaMatchesNamesExactly(t *testing.T) {
+
+	valid := `{"category":"nature","description":{"name":"Gražuolis","hours":{"wkd":"08:00-20:00","std":"08:00-20:00","snd":"08:00-20:00"},
+		"info":"Labai graži vieta prie upės su takais"},
+		"location":{"city":"Vilnius","coordinates":{"latitude":54.7,"longitude":25.3}}}`
+
+	if codes := schemaErrors(t, valid); len(codes) != 0 {
+		t.Fatalf("valid attraction has errors %v", codes)
+	}
+
+	codes := schemaErrors(t, `{"Category":"nature","description":{"Name":"Gražuolis","info":"Labai graži vieta prie upės"},
+		"location":{"city":"Vilnius","coordinates":{"latitude":54.7,"longitude":25.3}}}`)
+
+	for path, code := range map[string]string{
+		"Category":         code_unknown_field,
+		"category":         code_required,
+		"description.Name": code_unknown_field,
+		"description.name": code_required,
+	} {
+		if codes[path] != code {
+			t.Errorf("%s has code %q, want %q", path, codes[path], code)
+		}
+	}
+}
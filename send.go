@@ -0,0 +1,366 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/jpeg"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"time"
+
+	"github.com/MingaudasVagonis/go-attractions-server/signature"
+)
+
+// Formats images can be posted in.
+var send_formats = []string{"ndjson", "multipart"}
+
+// Maximum size of the receiver's response with results of the batch's images.
+const max_results_size = 1 << 20
+
+// Function takes in a slice of processed Downloadables, a url string to send
+// the images to and a reference to a slice of Failures. Processed images are
+// encoded as jpegs and posted in batches of config.Send.BatchSize. Images the
+// receiver didn't accept are retried or added to the failed slice one by one.
+// Returns the number of images that were sent successfully.
+func send(downloadables []Downloadable, url string, failed *[]Failure) int {
+
+	client := &http.Client{
+		Timeout: time.Duration(config.Send.Timeout) * time.Second,
+		// Redirects that change the method to GET would drop the images, 307 and 308 replay the body.
+		CheckRedirect: func(request *http.Request, via []*http.Request) error {
+			if request.Method != http.MethodPost {
+				return fmt.Errorf("url redirected to %s with %s, images can only be posted", request.URL, request.Method)
+			}
+			if len(via) >= 10 {
+				return errors.New("url redirected too many times")
+			}
+			return nil
+		},
+	}
+	sent := 0
+
+	for start := 0; start < len(downloadables); start += config.Send.BatchSize {
+
+		end := start + config.Send.BatchSize
+		if end > len(downloadables) {
+			end = len(downloadables)
+		}
+
+		// Encoding the batch, images that fail to encode are left out.
+		batch := make([]Downloadable, 0, end-start)
+		images := make([][]byte, 0, end-start)
+
+		for _, down := range downloadables[start:end] {
+			data, err := encodeJpeg(down)
+			if err != nil {
+				*failed = append(*failed, down.failure("encode failed: "+err.Error()))
+				continue
+			}
+			batch = append(batch, down)
+			images = append(images, data)
+		}
+
+		if len(batch) == 0 {
+			continue
+		}
+
+		sent += sendBatch(client, url, batch, images, failed)
+	}
+
+	return sent
+}
+
+// Function takes in a http.Client, url, a batch of Downloadables with their encoded images
+// and a reference to a slice of Failures. The batch is posted and images the receiver couldn't
+// store for now are posted again, up to config.Send.Retries times. Images that were rejected
+// or still failed after the last attempt are added to the failed slice. Returns the number
+// of images that were sent successfully.
+func sendBatch(client *http.Client, url string, batch []Downloadable, images [][]byte, failed *[]Failure) int {
+
+	sent := 0
+
+	for attempt := 0; len(batch) > 0; attempt++ {
+
+		// Waiting longer after every failed attempt.
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * time.Second)
+		}
+
+		last := attempt == config.Send.Retries
+
+		results, err := postBatch(client, url, newBatchBody(batch, images))
+		if err != nil {
+			// Client errors won't be fixed by repeating the request.
+			var status *StatusError
+			if last || (errors.As(err, &status) && !status.retryable()) {
+				for _, down := range batch {
+					*failed = append(*failed, down.failure("send failed: "+err.Error()))
+				}
+				return sent
+			}
+			continue
+		}
+
+		// Images to post again, in place of the batch.
+		retry, retry_images := batch[:0:0], images[:0:0]
+
+		for ind, down := range batch {
+
+			result, ok := results[ImageKey{down.id, down.position}]
+
+			switch {
+			case !ok || result.Status < 300:
+				sent++
+			case result.retryable() && !last:
+				retry, retry_images = append(retry, down), append(retry_images, images[ind])
+			default:
+				*failed = append(*failed, down.failure(fmt.Sprintf("receiver responded with %d: %s", result.Status, result.Error)))
+			}
+		}
+
+		batch, images = retry, retry_images
+	}
+
+	return sent
+}
+
+// Function takes in a Downloadable and returns its processed image encoded
+// as a jpeg with compression 80, or the jpeg of an upload that was processed
+// when it was uploaded, and an error if it occurs.
+func encodeJpeg(down Downloadable) ([]byte, error) {
+	if down.encoded != nil {
+		return down.encoded, nil
+	}
+	var buffer bytes.Buffer
+	err := jpeg.Encode(&buffer, down.decoded_img, &jpeg.Options{Quality: 80})
+	return buffer.Bytes(), err
+}
+
+// Request body of a batch in the configured format. The body is encoded every time it's
+// written rather than kept in memory, only the encoded images are.
+type BatchBody struct {
+	batch  []Downloadable
+	images [][]byte
+	// Boundary of multipart bodies, the same for every write so the signature matches.
+	boundary string
+}
+
+// Function takes in a batch of Downloadables and their encoded images and returns a reference to a BatchBody.
+func newBatchBody(batch []Downloadable, images [][]byte) *BatchBody {
+	return &BatchBody{batch, images, multipart.NewWriter(nil).Boundary()}
+}
+
+// Function returns the content type of the body.
+func (b *BatchBody) contentType() string {
+
+	if config.Send.Format == "multipart" {
+		return "multipart/form-data; boundary=" + b.boundary
+	}
+
+	return "application/x-ndjson"
+}
+
+// Function takes in an io.Writer and writes the body to it. Returns an error if it occurs.
+func (b *BatchBody) write(destination io.Writer) error {
+
+	if config.Send.Format == "multipart" {
+
+		writer := multipart.NewWriter(destination)
+		if err := writer.SetBoundary(b.boundary); err != nil {
+			return err
+		}
+
+		// Metadata of every image is sent as a single json field.
+		metadata := make([]ImageMetadata, 0, len(b.batch))
+		for _, down := range b.batch {
+			metadata = append(metadata, down.metadata())
+		}
+
+		data, _ := json.Marshal(metadata)
+		if err := writer.WriteField("metadata", string(data)); err != nil {
+			return err
+		}
+
+		// Every image is sent as a separate file named <id>-<position>.jpg
+		for ind, down := range b.batch {
+
+			header := make(textproto.MIMEHeader)
+			header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="image"; filename="%s-%d.jpg"`, down.id, down.position))
+			header.Set("Content-Type", "image/jpeg")
+
+			part, err := writer.CreatePart(header)
+			if err != nil {
+				return err
+			}
+			if _, err := part.Write(b.images[ind]); err != nil {
+				return err
+			}
+		}
+
+		return writer.Close()
+	}
+
+	// One json object with image metadata and base64 encoded image per line.
+	encoder := json.NewEncoder(destination)
+
+	for ind, down := range b.batch {
+		err := encoder.Encode(ImageLine{down.metadata(), base64.StdEncoding.EncodeToString(b.images[ind])})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Function takes in a http.Client, url and a reference to the request BatchBody and posts the
+// body signed with config.Send.Secret. The body is streamed to the url. Returns results of the
+// batch's images the receiver listed in a json response and an error if the request failed.
+func postBatch(client *http.Client, url string, body *BatchBody) (map[ImageKey]ImageResult, error) {
+
+	// The body is written once to compute the signature and again to send it, every
+	// attempt is signed again so the timestamp stays within the receiver's window.
+	signer := signature.NewSigner([]byte(config.Send.Secret))
+	if err := body.write(signer); err != nil {
+		return nil, err
+	}
+
+	request, err := http.NewRequest("POST", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Streamed bodies can't be read twice, redirects get a new stream of the same body.
+	request.GetBody = func() (io.ReadCloser, error) {
+		return body.reader(), nil
+	}
+	request.Body = body.reader()
+
+	request.Header.Set("Content-Type", body.contentType())
+	signer.SetHeaders(request)
+
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return nil, &StatusError{response.StatusCode, response.Status}
+	}
+
+	return readResults(response)
+}
+
+// Function returns an io.ReadCloser the body is written to as it's read. Closing the
+// reader stops the writer.
+func (b *BatchBody) reader() io.ReadCloser {
+
+	reader, writer := io.Pipe()
+
+	go func() {
+		writer.CloseWithError(b.write(writer))
+	}()
+
+	return reader
+}
+
+// Function takes in a reference to a successful http.Response and returns results of images
+// listed in its json body. Receivers that don't respond with json accepted every image.
+func readResults(response *http.Response) (map[ImageKey]ImageResult, error) {
+
+	results := map[ImageKey]ImageResult{}
+
+	media, _, _ := mime.ParseMediaType(response.Header.Get("Content-Type"))
+	if media != "application/json" {
+		return results, nil
+	}
+
+	var list []ImageResult
+	if err := json.NewDecoder(io.LimitReader(response.Body, max_results_size)).Decode(&list); err != nil {
+		return nil, fmt.Errorf("url responded with invalid results: %s", err.Error())
+	}
+
+	for _, result := range list {
+		results[ImageKey{result.Id, result.Position}] = result
+	}
+
+	return results, nil
+}
+
+// Error of a request the url responded to with an unsuccessful status.
+type StatusError struct {
+	code   int
+	status string
+}
+
+func (e *StatusError) Error() string {
+	return "url responded with " + e.status
+}
+
+// Function returns whether repeating the request may succeed, client errors other than
+// 429 Too Many Requests won't be fixed by it.
+func (e *StatusError) retryable() bool {
+	return e.code >= 500 || e.code == http.StatusTooManyRequests
+}
+
+// Attraction's id and position that identify an image of its gallery.
+type ImageKey struct {
+	id       string
+	position int
+}
+
+// Result of an image the receiver responds with, statuses are the same as HTTP statuses.
+// Images that are missing from the response were accepted.
+type ImageResult struct {
+	Id       string `json:"id"`
+	Position int    `json:"position"`
+	Status   int    `json:"status"`
+	Error    string `json:"error"`
+}
+
+// Function returns whether posting the image again may succeed.
+func (r ImageResult) retryable() bool {
+	return r.Status >= 500 || r.Status == http.StatusTooManyRequests
+}
+
+// Function returns ImageMetadata of the processed Downloadable.
+func (d *Downloadable) metadata() ImageMetadata {
+	return ImageMetadata{
+		Id:          d.id,
+		Position:    d.position,
+		Primary:     d.info.primary,
+		Caption:     d.info.caption.String,
+		Author:      d.info.author.String,
+		Licence:     d.info.licence,
+		Attribution: d.info.attribution(),
+		Blurhash:    d.blurhash,
+		Colour:      d.colour,
+	}
+}
+
+// Metadata of a posted image.
+type ImageMetadata struct {
+	Id          string `json:"id"`
+	Position    int    `json:"position"`
+	Primary     bool   `json:"primary"`
+	Caption     string `json:"caption"`
+	Author      string `json:"author"`
+	Licence     string `json:"licence"`
+	Attribution string `json:"attribution"`
+	Blurhash    string `json:"blurhash"`
+	Colour      string `json:"colour"`
+}
+
+// Line of a NDJSON body, metadata and the base64 encoded image.
+type ImageLine struct {
+	ImageMetadata
+	Image string `json:"image"`
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"image"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/MingaudasVagonis/go-attractions-server/signature"
+)
+
+// Receiver of posted images that records ids of every request's images and
+// responds with the results returned by respond.
+type testReceiver struct {
+	mutex    sync.Mutex
+	requests [][]string
+	respond  func(attempt int, lines []ImageLine) []ImageResult
+}
+
+func (r *testReceiver) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+
+	body, err := signature.VerifyRequest(request, []byte(config.Send.Secret), signature.DefaultTolerance, 1<<20)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var lines []ImageLine
+	scanner := bufio.NewScanner(strings.NewReader(string(body)))
+	scanner.Buffer(nil, 1<<20)
+	ids := make([]string, 0)
+	for scanner.Scan() {
+		var line ImageLine
+		json.Unmarshal(scanner.Bytes(), &line)
+		lines = append(lines, line)
+		ids = append(ids, line.Id)
+	}
+
+	r.mutex.Lock()
+	attempt := len(r.requests)
+	r.requests = append(r.requests, ids)
+	r.mutex.Unlock()
+
+	writer.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(writer).Encode(r.respond(attempt, lines))
+}
+
+// Function takes in ids and returns processed Downloadables with a small image.
+func testDownloadables(ids ...string) []Downloadable {
+
+	downs := make([]Downloadable, 0, len(ids))
+	for _, id := range ids {
+		downs = append(downs, Downloadable{id: id, decoded_img: image.NewRGBA(image.Rect(0, 0, 30, 20))})
+	}
+	return downs
+}
+
+// Function sets the send configuration used by the tests and returns a function that restores it.
+func testSendConfig() func() {
+
+	previous := config.Send
+	config.Send.Secret, config.Send.Format, config.Send.Retries, config.Send.BatchSize = "secret", "ndjson", 2, 10
+
+	return func() { config.Send = previous }
+}
+
+func TestSendReportsImagesOneByOne(t *testing.T) {
+
+	defer testSendConfig()()
+
+	receiver := &testReceiver{respond: func(attempt int, lines []ImageLine) []ImageResult {
+		results := make([]ImageResult, 0)
+		for _, line := range lines {
+			switch {
+			case line.Id == "rejected":
+				results = append(results, ImageResult{line.Id, line.Position, http.StatusUnprocessableEntity, "too small"})
+			case line.Id == "busy" && attempt == 0:
+				results = append(results, ImageResult{line.Id, line.Position, http.StatusServiceUnavailable, "try later"})
+			}
+		}
+		return results
+	}}
+
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	var failed []Failure
+	sent := send(testDownloadables("accepted", "rejected", "busy"), server.URL, &failed)
+
+	if sent != 2 {
+		t.Errorf("sent %d images, want 2", sent)
+	}
+	if len(failed) != 1 || failed[0].key() != "rejected#0" || !strings.Contains(failed[0].reason, "too small") {
+		t.Errorf("failed images are %v", failed)
+	}
+
+	// Only the image the receiver couldn't store is posted again.
+	if len(receiver.requests) != 2 || strings.Join(receiver.requests[1], ",") != "busy" {
+		t.Errorf("requests posted %v", receiver.requests)
+	}
+}
+
+func TestSendDoesntRepeatClientErrors(t *testing.T) {
+
+	defer testSendConfig()()
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		requests++
+		io.Copy(io.Discard, request.Body)
+		http.Error(writer, "bad", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	var failed []Failure
+	if sent := send(testDownloadables("a", "b"), server.URL, &failed); sent != 0 || len(failed) != 2 || requests != 1 {
+		t.Fatalf("sent %d images in %d requests, failed %v", sent, requests, failed)
+	}
+}
+
+func TestSendFollowsOnlyBodyPreservingRedirects(t *testing.T) {
+
+	defer testSendConfig()()
+
+	receiver := &testReceiver{respond: func(int, []ImageLine) []ImageResult { return nil }}
+	mux := http.NewServeMux()
+	mux.Handle("/images", receiver)
+	mux.Handle("/moved", http.RedirectHandler("/images", http.StatusPermanentRedirect))
+	mux.Handle("/found", http.RedirectHandler("/images", http.StatusFound))
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	var failed []Failure
+	if sent := send(testDownloadables("a"), server.URL+"/moved", &failed); sent != 1 || len(failed) != 0 {
+		t.Fatalf("308 redirect sent %d images, failed %v", sent, failed)
+	}
+
+	failed = nil
+	if sent := send(testDownloadables("a"), server.URL+"/found", &failed); sent != 0 || len(failed) != 1 ||
+		!strings.Contains(failed[0].reason, "images can only be posted") {
+		t.Fatalf("302 redirect sent %d images, failed %v", sent, failed)
+	}
+}
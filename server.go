@@ -1,8 +1,9 @@
 package main
 
 import (
-	"database/sql"
+	"bytes"
 	"encoding/json"
+	"image"
 	"log"
 	"net/http"
 
@@ -13,17 +14,18 @@ import (
 const match_threshold = 0.5
 
 type Server struct {
-	url        string
-	connection *sql.DB
-	router     *mux.Router
+	url    string
+	store  AttractionStore
+	router *mux.Router
+	images *ImageStore
 }
 
 // Function starts the server.
 func (s *Server) Start() {
 	s.router = mux.NewRouter()
 
-	// Getting the connection to the cache database.
-	getConnection("./assets/cache.db", &s.connection)
+	// see imagestore.go
+	s.images = newImageStore(config.Store.Root)
 
 	s.createRoutes()
 
@@ -33,10 +35,41 @@ func (s *Server) Start() {
 
 // Function adds routes to the server
 func (s *Server) createRoutes() {
-	// /add route used to add an attraction to the server.
-	s.router.HandleFunc("/add", s.addAttraction).Methods("POST")
-	// /check route used to get similar attractions in the database.
-	s.router.HandleFunc("/check", s.checkAvailability).Methods("GET").Queries("name", "{name}")
+	// Every version is served under its prefix, e.g. /v1/add, see versions.go
+	for _, version := range s.versions() {
+		version.register(s.router.PathPrefix(version.prefix).Subrouter())
+	}
+
+	// Routes that existed before versioning stay as deprecated aliases of /v1 until sunset_at.
+	s.v1().deprecated(unversioned_paths).register(s.router)
+}
+
+// Function returns the routes of the first version of the API.
+func (s *Server) v1() ApiVersion {
+	return ApiVersion{v1_prefix, []Route{
+		// /add route used to add an attraction to the server.
+		{method: "POST", path: "/add", handler: s.addAttraction},
+		// /add/batch route used to add several attractions at once, see batch.go
+		{method: "POST", path: "/add/batch", handler: s.addAttractions},
+		// /schema/attraction.json route used to get the JSON Schema of the /add body, see schema.go
+		{method: "GET", path: schema_path, handler: s.getSchema},
+		// /openapi.json route used to get the OpenAPI document of the routes, see openapi.go
+		{method: "GET", path: openapi_path, handler: s.getSpec},
+		// /docs route used to browse and try out the OpenAPI document, see openapi.go
+		{method: "GET", path: docs_path, handler: s.getDocs},
+		// /check route used to get similar attractions in the database.
+		{method: "GET", path: "/check", handler: s.checkAvailability, queries: []string{"name", "{name}"}},
+		// /attractions route used to list attractions in the cache.
+		{method: "GET", path: "/attractions", handler: s.getAttractions},
+		// /attractions/{id} route used to get a single attraction.
+		{method: "GET", path: "/attractions/{id}", handler: s.getAttraction},
+		// /attractions/{id}/images route used to upload an attraction's image, see upload.go
+		{method: "POST", path: "/attractions/{id}/images", handler: s.uploadImage},
+		// /images/{hash} route used to get a stored image or its rendition, see imagestore.go
+		{method: "GET", path: "/images/{hash:[0-9a-f]{64}}", handler: s.serveImage},
+		// /check/image route used to get attractions with a similar image.
+		{method: "GET", path: "/check/image", handler: s.checkImage, queries: []string{"url", "{url}"}},
+	}}
 }
 
 // Route handler to add an attraction to the database.
@@ -48,16 +81,17 @@ func (s *Server) addAttraction(writer http.ResponseWriter, request *http.Request
 	rattr, err := validateAttraction(writer, request)
 
 	if rattr == nil {
-		respond(writer, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		// Invalid values are responded with 422, see messages.go
+		respondError(writer, request, err)
 		return
 	}
 
 	// Wrapping RawAttraction into an Attraction struct.
 	attraction := rattr.wrap()
 
-	// Committing the Attraction to the cache database.
-	if err := s.commitAttraction(&attraction); err != nil {
-		respond(writer, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	// Committing the Attraction to the store, see store.go
+	if err := s.store.add(&attraction); err != nil {
+		respondError(writer, request, err)
 		return
 	}
 
@@ -70,11 +104,11 @@ func (s *Server) addAttraction(writer http.ResponseWriter, request *http.Request
 func (s *Server) checkAvailability(writer http.ResponseWriter, request *http.Request) {
 
 	id := toID(request.FormValue("name"))
-	// Getting ids and ame in the database, see db.go
-	titles, err := s.readTitles()
+	// Getting ids and ame in the database, see store.go
+	titles, err := s.store.titles()
 
 	if err != nil {
-		respond(writer, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		respondError(writer, request, err)
 		return
 	}
 
@@ -90,6 +124,81 @@ func (s *Server) checkAvailability(writer http.ResponseWriter, request *http.Req
 	respond(writer, http.StatusOK, matches)
 }
 
+// Route handler to list attractions in the cache.
+// Function takes in the standart handler parameters http.ResponseWriter and a reference
+// to a http.Request and reponds with an error or an array of attractions.
+func (s *Server) getAttractions(writer http.ResponseWriter, request *http.Request) {
+
+	// see store.go
+	attractions, err := s.store.list()
+
+	if err != nil {
+		respondError(writer, request, err)
+		return
+	}
+
+	views := make([]AttractionView, 0, len(attractions))
+	for _, a := range attractions {
+		views = append(views, a.view())
+	}
+
+	respond(writer, http.StatusOK, views)
+}
+
+// Route handler to get a single attraction.
+// Function takes in the standart handler parameters http.ResponseWriter and a reference
+// to a http.Request and reponds with an error or the attraction.
+func (s *Server) getAttraction(writer http.ResponseWriter, request *http.Request) {
+
+	// see store.go
+	attraction, err := s.store.get(mux.Vars(request)["id"])
+
+	if err != nil {
+		respondError(writer, request, err)
+		return
+	}
+
+	if attraction == nil {
+		respondError(writer, request, apiError(http.StatusNotFound, code_not_found))
+		return
+	}
+
+	respond(writer, http.StatusOK, attraction.view())
+}
+
+// Route handler to check whether an image is already used by an attraction.
+// Function takes in the standart handler parameters http.ResponseWriter and a reference
+// to a http.Request and reponds with an error or an array of matching attractions.
+func (s *Server) checkImage(writer http.ResponseWriter, request *http.Request) {
+
+	// Downloading and decoding the candidate image, see retrieve.go
+	data, err := fetchImage(request.FormValue("url"))
+	if err != nil {
+		// The cause isn't shown so the route can't be used to probe the network.
+		log.Printf("%s %s: %s", request.Method, request.URL.Path, err.Error())
+		respondError(writer, request, apiError(http.StatusBadRequest, code_image_fetch_failed))
+		return
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		respondError(writer, request, apiError(http.StatusBadRequest, code_image_decode_failed))
+		return
+	}
+
+	// Hashing the image the same way it would be hashed during merge, see phash.go
+	orientation, _ := readExif(data)
+	hash := dhash(orient(img, orientation))
+
+	hashes, err := s.store.hashes()
+	if err != nil {
+		respondError(writer, request, err)
+		return
+	}
+
+	respond(writer, http.StatusOK, findMatches(hash, "", hashes))
+}
+
 // Helper function that responds to a request. Function takes in http.ResponseWriter, status
 // code, and data object that is used as a response body.
 func respond(writer http.ResponseWriter, code int, data interface{}) {
@@ -0,0 +1,146 @@
+// Package signature signs and verifies requests that post processed
+// images to an external service. Signature is a hex encoded HMAC-SHA256
+// of the request timestamp and body, "<timestamp>.<body>", computed with
+// a shared secret.
+package signature
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Header that contains the signature as "sha256=<hex>".
+	SignatureHeader = "X-Signature"
+	// Header that contains unix time in seconds the request was signed at.
+	TimestampHeader = "X-Signature-Timestamp"
+	// Window a request is accepted in by default.
+	DefaultTolerance = 5 * time.Minute
+)
+
+const prefix = "sha256="
+
+var (
+	ErrMissing   = errors.New("signature: missing signature headers")
+	ErrTimestamp = errors.New("signature: invalid timestamp")
+	ErrExpired   = errors.New("signature: timestamp outside of tolerance window")
+	ErrMismatch  = errors.New("signature: signature mismatch")
+	ErrTooLarge  = errors.New("signature: body is too large")
+)
+
+// Sign takes in a secret, unix timestamp and request body and
+// returns the value of the SignatureHeader.
+func Sign(secret []byte, timestamp int64, body []byte) string {
+	return prefix + hex.EncodeToString(compute(secret, timestamp, body))
+}
+
+// SignRequest sets signature headers of the request with the
+// given body, signed at the current time.
+func SignRequest(request *http.Request, secret []byte, body []byte) {
+	signer := NewSigner(secret)
+	signer.Write(body)
+	signer.SetHeaders(request)
+}
+
+// Signer computes the signature of a body written to it, used to sign
+// bodies that are streamed rather than kept in memory.
+type Signer struct {
+	timestamp int64
+	mac       hash.Hash
+}
+
+// NewSigner takes in a secret and returns a Signer of a body signed at the current time.
+func NewSigner(secret []byte) *Signer {
+	timestamp := time.Now().Unix()
+	return &Signer{timestamp, start(secret, timestamp)}
+}
+
+// Write adds a part of the body to the signature, it never returns an error.
+func (s *Signer) Write(p []byte) (int, error) {
+	return s.mac.Write(p)
+}
+
+// SetHeaders sets signature headers of the request with the body written to the Signer.
+func (s *Signer) SetHeaders(request *http.Request) {
+	request.Header.Set(TimestampHeader, strconv.FormatInt(s.timestamp, 10))
+	request.Header.Set(SignatureHeader, prefix+hex.EncodeToString(s.mac.Sum(nil)))
+}
+
+// Verify takes in a secret, values of the signature headers, request body,
+// tolerance window and current time. An error is returned if the timestamp
+// is outside of the window, which protects from replayed requests, or the
+// signature doesn't match.
+func Verify(secret []byte, signature, timestamp string, body []byte, tolerance time.Duration, now time.Time) error {
+
+	if signature == "" || timestamp == "" {
+		return ErrMissing
+	}
+
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrTimestamp
+	}
+
+	if diff := now.Sub(time.Unix(ts, 0)); diff > tolerance || diff < -tolerance {
+		return ErrExpired
+	}
+
+	if !strings.HasPrefix(signature, prefix) {
+		return ErrMismatch
+	}
+
+	given, err := hex.DecodeString(strings.TrimPrefix(signature, prefix))
+	if err != nil {
+		return ErrMismatch
+	}
+
+	// Constant time comparison so the signature can't be guessed byte by byte.
+	if !hmac.Equal(given, compute(secret, ts, body)) {
+		return ErrMismatch
+	}
+
+	return nil
+}
+
+// VerifyRequest reads the body of the request, at most limit bytes, and verifies
+// its signature against the current time. Returns the body and an error if the
+// body is larger than the limit or verification fails.
+func VerifyRequest(request *http.Request, secret []byte, tolerance time.Duration, limit int64) ([]byte, error) {
+
+	// Reading one byte past the limit tells a body of exactly limit bytes from a larger one.
+	body, err := ioutil.ReadAll(io.LimitReader(request.Body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(body)) > limit {
+		return nil, ErrTooLarge
+	}
+
+	err = Verify(secret, request.Header.Get(SignatureHeader), request.Header.Get(TimestampHeader), body, tolerance, time.Now())
+
+	return body, err
+}
+
+func compute(secret []byte, timestamp int64, body []byte) []byte {
+	mac := start(secret, timestamp)
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+// start returns the HMAC of the timestamp the body is written to.
+func start(secret []byte, timestamp int64) hash.Hash {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	return mac
+}
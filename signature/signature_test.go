@@ -0,0 +1,102 @@
+package signature
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	secret = []byte("secret")
+	body   = []byte(`{"id":"vilnius","position":0}`)
+	now    = time.Unix(1700000000, 0)
+)
+
+func TestVerify(t *testing.T) {
+
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	valid := Sign(secret, now.Unix(), body)
+
+	for _, test := range []struct {
+		name                 string
+		secret               []byte
+		signature, timestamp string
+		body                 []byte
+		now                  time.Time
+		expected             error
+	}{
+		{"valid", secret, valid, timestamp, body, now, nil},
+		{"within tolerance", secret, valid, timestamp, body, now.Add(DefaultTolerance), nil},
+		{"tampered body", secret, valid, timestamp, []byte(`{"id":"kaunas","position":0}`), now, ErrMismatch},
+		{"wrong secret", []byte("other"), valid, timestamp, body, now, ErrMismatch},
+		{"expired", secret, valid, timestamp, body, now.Add(DefaultTolerance + time.Second), ErrExpired},
+		{"from the future", secret, valid, timestamp, body, now.Add(-DefaultTolerance - time.Second), ErrExpired},
+		{"other timestamp", secret, valid, strconv.FormatInt(now.Unix()+1, 10), body, now, ErrMismatch},
+		{"missing signature", secret, "", timestamp, body, now, ErrMissing},
+		{"missing timestamp", secret, valid, "", body, now, ErrMissing},
+		{"malformed timestamp", secret, valid, "yesterday", body, now, ErrTimestamp},
+		{"missing prefix", secret, strings.TrimPrefix(valid, prefix), timestamp, body, now, ErrMismatch},
+		{"other algorithm", secret, "sha1=" + strings.TrimPrefix(valid, prefix), timestamp, body, now, ErrMismatch},
+		{"malformed hex", secret, prefix + "zz", timestamp, body, now, ErrMismatch},
+	} {
+		if err := Verify(test.secret, test.signature, test.timestamp, test.body, DefaultTolerance, test.now); err != test.expected {
+			t.Errorf("%s: Verify returned %v, want %v", test.name, err, test.expected)
+		}
+	}
+}
+
+func TestVerifyRequest(t *testing.T) {
+
+	for _, test := range []struct {
+		name     string
+		sign     func(secret, body []byte) map[string]string
+		body     []byte
+		limit    int64
+		expected error
+	}{
+		{"valid", signedNow, body, int64(len(body)), nil},
+		{"tampered body", signedNow, append(body[:len(body):len(body)], ' '), 1 << 10, ErrMismatch},
+		{"expired", signedAt(now), body, 1 << 10, ErrExpired},
+		{"from the future", signedAt(time.Now().Add(time.Hour)), body, 1 << 10, ErrExpired},
+		{"missing headers", func([]byte, []byte) map[string]string { return nil }, body, 1 << 10, ErrMissing},
+		{"malformed timestamp", func(secret, body []byte) map[string]string {
+			return map[string]string{SignatureHeader: Sign(secret, 0, body), TimestampHeader: "0x10"}
+		}, body, 1 << 10, ErrTimestamp},
+		{"too large", signedNow, body, int64(len(body)) - 1, ErrTooLarge},
+	} {
+
+		request := httptest.NewRequest("POST", "/images", bytes.NewReader(test.body))
+		for key, value := range test.sign(secret, body) {
+			request.Header.Set(key, value)
+		}
+
+		read, err := VerifyRequest(request, secret, DefaultTolerance, test.limit)
+		if err != test.expected {
+			t.Errorf("%s: VerifyRequest returned %v, want %v", test.name, err, test.expected)
+		}
+		if err == nil && !bytes.Equal(read, test.body) {
+			t.Errorf("%s: VerifyRequest returned body %q", test.name, read)
+		}
+	}
+}
+
+// Function takes in a time and returns a function that returns signature headers of a body signed at that time.
+func signedAt(at time.Time) func(secret, body []byte) map[string]string {
+	return func(secret, body []byte) map[string]string {
+		return map[string]string{SignatureHeader: Sign(secret, at.Unix(), body), TimestampHeader: strconv.FormatInt(at.Unix(), 10)}
+	}
+}
+
+// Function returns signature headers of a body signed by a Signer at the current time.
+func signedNow(secret, body []byte) map[string]string {
+
+	request := httptest.NewRequest("POST", "/", nil)
+	signer := NewSigner(secret)
+	signer.Write(body)
+	signer.SetHeaders(request)
+
+	return map[string]string{SignatureHeader: request.Header.Get(SignatureHeader), TimestampHeader: request.Header.Get(TimestampHeader)}
+}
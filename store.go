@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Both stores implement AttractionStore.
+var (
+	_ AttractionStore = (*SQLiteStore)(nil)
+	_ AttractionStore = (*MemoryStore)(nil)
+)
+
+// Error returned when updating or deleting an attraction that isn't in the store.
+var errNotFound = errors.New("Attraction not found")
+
+// Error returned when adding an image to a gallery of max_gallery_size images.
+var errGalleryFull = errors.New("Gallery is full")
+
+// Error returned when adding an attraction whose id is already in the store.
+type ExistsError struct {
+	id string
+}
+
+func (e *ExistsError) Error() string {
+	return fmt.Sprintf("Attraction %s already exists", e.id)
+}
+
+// Storage of attractions, their galleries, titles and merge bookkeeping used by the
+// server and commands. SQLiteStore keeps them in the cache database (see db.go),
+// MemoryStore keeps them in memory (see memstore.go).
+type AttractionStore interface {
+	// Function adds an attraction with its title and gallery.
+	add(a *Attraction) error
+	// Function adds attractions in a single transaction, none are added if one fails.
+	// An ExistsError is returned for the first attraction that exists or is repeated.
+	addAll(attractions []Attraction) error
+	// Function returns an attraction with its gallery or nil if it doesn't exist.
+	get(id string) (*Attraction, error)
+	// Function returns all attractions with their galleries ordered by id.
+	list() ([]Attraction, error)
+	// Function updates an attraction's values, its gallery is left as is.
+	update(a *Attraction) error
+	// Function deletes an attraction with its title, gallery and merge records.
+	delete(id string) error
+
+	// Function returns titles used to check whether an attraction exists.
+	titles() (*TitleValues, error)
+	addTitles(titles ...Title) error
+
+	// Function adds an image to the end of an attraction's gallery and returns its position,
+	// errGalleryFull is returned if the gallery already has max_gallery_size images.
+	addImage(id string, img GalleryImage) (int, error)
+	// Function returns perceptual hashes of all processed images.
+	hashes() ([]ImageHash, error)
+	// Function records hashes and placeholders of processed images.
+	storeProcessed(downloadables []Downloadable) error
+	// Function records hashes of images saved to the image store.
+	storeSaved(downloadables []Downloadable, saved map[string]string) error
+
+	// Function returns a map of merged attractions' ids to their checksums.
+	merged() (map[string]string, error)
+	markMerged(attractions []Attraction, batch string) error
+	// Function records a merge run as pending before the target commits.
+	recordRun(id string, started time.Time, target string, result *MergeResult) error
+	// Function sets the status of a merge run once the target committed or failed to.
+	finishRun(id, status string) error
+	recordFailures(id string, failed []Failure) error
+	// Function returns a merge run or nil if it doesn't exist.
+	readRun(id string) (*MergeRun, error)
+	// Function marks a merge run as rolled back and its attractions as not merged.
+	markRolledBack(id string) error
+
+	close() error
+}
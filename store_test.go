@@ -0,0 +1,321 @@
+package main
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Function takes in a test and calls it with every AttractionStore backend,
+// SQLiteStore uses a new database in a temporary directory.
+func forEachStore(t *testing.T, test func(t *testing.T, store AttractionStore)) {
+
+	t.Run("memory", func(t *testing.T) {
+		test(t, newMemoryStore())
+	})
+
+	t.Run("sqlite", func(t *testing.T) {
+		store, err := openSQLiteStore(filepath.Join(t.TempDir(), "cache.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer store.close()
+		test(t, store)
+	})
+}
+
+// Function takes in an id and returns an Attraction with a single primary image.
+func testAttraction(id string) Attraction {
+	return Attraction{
+		id:          id,
+		name:        id,
+		category:    "nature",
+		description: `{"Name":"` + id + `"}`,
+		location:    `{"City":"Vilnius","Coordinates":{"Latitude":54.7,"Longitude":25.3}}`,
+		images: []GalleryImage{{
+			position: 0,
+			url:      createNullString("https://example.com/" + id + ".jpg"),
+			author:   createNullString("Jonas"),
+			licence:  "cc-by",
+			primary:  true,
+		}},
+	}
+}
+
+func TestStoreAddGet(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store AttractionStore) {
+
+		a := testAttraction("vilnius")
+		if err := store.add(&a); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := store.get("vilnius")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got == nil || got.category != a.category || got.location != a.location {
+			t.Fatalf("get returned %+v, want %+v", got, a)
+		}
+		if len(got.images) != 1 || got.images[0].url.String != a.images[0].url.String || !got.images[0].primary {
+			t.Fatalf("get returned gallery %+v, want %+v", got.images, a.images)
+		}
+
+		if missing, err := store.get("kaunas"); err != nil || missing != nil {
+			t.Fatalf("get of a missing attraction returned %+v, %v", missing, err)
+		}
+
+		if err := store.add(&a); err == nil {
+			t.Fatal("adding an existing attraction succeeded")
+		}
+
+		titles, err := store.titles()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(titles.compares) != 1 || titles.compares[0] != "vilnius" {
+			t.Fatalf("titles are %v, want [vilnius]", titles.compares)
+		}
+	})
+}
+
+func TestStoreAddAllAddsNothingOnConflict(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store AttractionStore) {
+
+		existing := testAttraction("vilnius")
+		if err := store.add(&existing); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := store.addAll([]Attraction{testAttraction("kaunas"), testAttraction("vilnius")}); err == nil {
+			t.Fatal("addAll with an existing attraction succeeded")
+		}
+
+		if err := store.addAll([]Attraction{testAttraction("klaipeda"), testAttraction("klaipeda")}); err == nil {
+			t.Fatal("addAll with a repeated attraction succeeded")
+		}
+
+		list, err := store.list()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(list) != 1 || list[0].id != "vilnius" {
+			t.Fatalf("list returned %d attractions, want only vilnius", len(list))
+		}
+	})
+}
+
+func TestStoreUpdateDelete(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store AttractionStore) {
+
+		a := testAttraction("vilnius")
+		if err := store.add(&a); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.markMerged([]Attraction{a}, "run"); err != nil {
+			t.Fatal(err)
+		}
+
+		a.category = "heritage"
+		a.images = nil
+		if err := store.update(&a); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := store.get("vilnius")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.category != "heritage" {
+			t.Fatalf("category is %q after update, want heritage", got.category)
+		}
+		if len(got.images) != 1 {
+			t.Fatalf("update changed the gallery to %d images", len(got.images))
+		}
+
+		missing := testAttraction("kaunas")
+		if err := store.update(&missing); err != errNotFound {
+			t.Fatalf("update of a missing attraction returned %v, want errNotFound", err)
+		}
+
+		if err := store.delete("vilnius"); err != nil {
+			t.Fatal(err)
+		}
+
+		if got, err := store.get("vilnius"); err != nil || got != nil {
+			t.Fatalf("get after delete returned %+v, %v", got, err)
+		}
+
+		titles, err := store.titles()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(titles.compares) != 0 {
+			t.Fatalf("titles are %v after delete, want none", titles.compares)
+		}
+
+		merged, err := store.merged()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := merged["vilnius"]; ok {
+			t.Fatal("delete left the merge record")
+		}
+
+		if err := store.delete("vilnius"); err != errNotFound {
+			t.Fatalf("second delete returned %v, want errNotFound", err)
+		}
+	})
+}
+
+func TestStoreGallery(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store AttractionStore) {
+
+		a := testAttraction("vilnius")
+		if err := store.add(&a); err != nil {
+			t.Fatal(err)
+		}
+
+		upload := GalleryImage{upload: createNullString("ab12"), author: createNullString("Ona"), licence: "cc-by", primary: true}
+		position, err := store.addImage("vilnius", upload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if position != 1 {
+			t.Fatalf("addImage returned position %d, want 1", position)
+		}
+
+		if _, err := store.addImage("kaunas", upload); err != errNotFound {
+			t.Fatalf("addImage to a missing attraction returned %v, want errNotFound", err)
+		}
+
+		got, err := store.get("vilnius")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if primary := got.primaryImage(); primary == nil || primary.position != 1 {
+			t.Fatalf("primary image is %+v, want the uploaded image", primary)
+		}
+		if got.copyright.String != "Ona, CC BY" {
+			t.Fatalf("copyright is %q, want the uploaded image's attribution", got.copyright.String)
+		}
+
+		down := Downloadable{id: "vilnius", position: 1, hash: 0xff, blurhash: "LEHV6n", colour: "#aabbcc"}
+		if err := store.storeProcessed([]Downloadable{down}); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.storeSaved([]Downloadable{down}, map[string]string{down.key(): "cd34"}); err != nil {
+			t.Fatal(err)
+		}
+
+		hashes, err := store.hashes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(hashes) != 1 || hashes[0] != (ImageHash{"vilnius", 1, 0xff}) {
+			t.Fatalf("hashes are %+v", hashes)
+		}
+
+		got, err = store.get("vilnius")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if img := got.images[1]; img.blurhash.String != "LEHV6n" || img.colour.String != "#aabbcc" || img.stored.String != "cd34" {
+			t.Fatalf("processed image is %+v", img)
+		}
+	})
+}
+
+func TestStoreMergeRuns(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store AttractionStore) {
+
+		a := testAttraction("vilnius")
+		if err := store.add(&a); err != nil {
+			t.Fatal(err)
+		}
+
+		started := time.Now().UTC().Truncate(time.Second)
+		result := &MergeResult{inserted: []string{"vilnius"}, rows: []MergeRow{{"vilnius", nil, targetRow(&a)}}}
+
+		if err := store.recordRun("run", started, "target.db", result); err != nil {
+			t.Fatal(err)
+		}
+
+		run, err := store.readRun("run")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if run == nil || run.status != run_pending || run.target != "target.db" || len(run.rows) != 1 {
+			t.Fatalf("recorded run is %+v", run)
+		}
+
+		if err := store.finishRun("run", run_committed); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.markMerged([]Attraction{a}, "run"); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.recordFailures("run", []Failure{{"vilnius", 0, "download failed"}}); err != nil {
+			t.Fatal(err)
+		}
+
+		run, err = store.readRun("run")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if run.status != run_committed || len(run.failed) != 1 || run.failed[0] != (FailedImage{"vilnius#0", "download failed"}) {
+			t.Fatalf("finished run is %+v", run)
+		}
+
+		merged, err := store.merged()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if merged["vilnius"] != a.checksum() {
+			t.Fatalf("merged checksum is %q, want %q", merged["vilnius"], a.checksum())
+		}
+
+		if err := store.markRolledBack("run"); err != nil {
+			t.Fatal(err)
+		}
+
+		run, err = store.readRun("run")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !run.rolled_back.Valid {
+			t.Fatal("run isn't marked as rolled back")
+		}
+
+		merged, err = store.merged()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(merged) != 0 {
+			t.Fatalf("rolled back attractions are still merged: %v", merged)
+		}
+
+		if missing, err := store.readRun("other"); err != nil || missing != nil {
+			t.Fatalf("readRun of a missing run returned %+v, %v", missing, err)
+		}
+	})
+}
+
+// Attractions whose gallery changes are merged again, values written by processing are ignored.
+func TestChecksumCoversGallery(t *testing.T) {
+
+	a := testAttraction("vilnius")
+	sum := a.checksum()
+
+	a.images[0].phash = sql.NullString{String: "00ff", Valid: true}
+	a.images[0].stored = sql.NullString{String: "ab12", Valid: true}
+	if a.checksum() != sum {
+		t.Fatal("processing the image changed the checksum")
+	}
+
+	a.images = append(a.images, GalleryImage{position: 1, url: createNullString("https://example.com/2.jpg"), licence: "cc-by"})
+	if a.checksum() == sum {
+		t.Fatal("adding an image didn't change the checksum")
+	}
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	_ "github.com/lib/pq"
+)
+
+// Columns of the destinations table merge writes to in the target database.
+var target_columns = []string{"id", "category", "description", "location", "copyright"}
+
+// Statements that create the schema expected in a SQLite target database.
+var sqlite_target_schema = []string{
+	`CREATE TABLE destinations (id TEXT PRIMARY KEY NOT NULL, category TEXT NOT NULL, description TEXT NOT NULL,
+		location TEXT NOT NULL, copyright TEXT)`,
+	`CREATE INDEX destinations_category ON destinations (category)`,
+}
+
+// Statements that create the schema expected in a PostgreSQL target database. Description
+// and location are stored as JSONB, coordinates from the location as a PostGIS geography.
+var postgres_target_schema = []string{
+	`CREATE EXTENSION IF NOT EXISTS postgis`,
+	`CREATE TABLE destinations (id TEXT PRIMARY KEY NOT NULL, category TEXT NOT NULL, description JSONB NOT NULL,
+		location JSONB NOT NULL, copyright TEXT, coordinates GEOGRAPHY(Point, 4326))`,
+	`CREATE INDEX destinations_category ON destinations (category)`,
+	`CREATE INDEX destinations_coordinates ON destinations USING GIST (coordinates)`,
+}
+
+// Query that counts tables and views of the current schema in a PostgreSQL database. Ones that
+// belong to extensions, e.g. spatial_ref_sys of PostGIS, don't make the database non-empty.
+const postgres_tables_query = `SELECT COUNT(*) FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+	WHERE n.nspname = current_schema() AND c.relkind IN ('r', 'p', 'v', 'm', 'f')
+	AND NOT EXISTS (SELECT 1 FROM pg_depend d WHERE d.classid = 'pg_class'::regclass AND d.objid = c.oid AND d.deptype = 'e')`
+
+// Target database merge writes attractions to.
+type Target struct {
+	connection *sql.DB
+	// Whether the target is a PostgreSQL database rather than SQLite.
+	postgres bool
+}
+
+// Function takes in a target database url and returns whether it is a PostgreSQL DSN.
+func isPostgres(target string) bool {
+	return strings.HasPrefix(target, "postgres://") || strings.HasPrefix(target, "postgresql://")
+}
+
+// Query parameters of a PostgreSQL DSN that hold credentials.
+var dsn_secrets = []string{"password", "sslpassword"}
+
+// Function takes in a target database url and returns it without credentials, so it can be
+// printed and recorded in the cache. SQLite paths are returned as they are. lib/pq reads the
+// password of a DSN without one from PGPASSWORD or ~/.pgpass.
+func redactTarget(target string) string {
+
+	if !isPostgres(target) {
+		return target
+	}
+
+	parsed, err := url.Parse(target)
+	if err != nil {
+		// Malformed DSNs can't be split into parts that are safe to print.
+		return strings.SplitN(target, "://", 2)[0] + "://"
+	}
+
+	if parsed.User != nil {
+		parsed.User = url.User(parsed.User.Username())
+	}
+
+	query := parsed.Query()
+	for _, key := range dsn_secrets {
+		query.Del(key)
+	}
+	parsed.RawQuery = query.Encode()
+
+	return parsed.String()
+}
+
+// Function takes in an url of the target database, either a path to a SQLite database
+// or a postgres:// DSN, and whether it should be opened read-only. The driver is chosen
+// by the url's scheme. Returns a reference to the Target and an error if it occurs.
+func openTarget(target string, read_only bool) (*Target, error) {
+
+	driver, dsn := "sqlite3", target
+
+	switch {
+
+	case isPostgres(target):
+		driver = "postgres"
+		parsed, err := url.Parse(target)
+		if err != nil {
+			// Parse errors quote the DSN with its password.
+			return nil, errors.New("malformed PostgreSQL DSN")
+		}
+		if read_only {
+			// Sent to the server as a run-time parameter of every connection.
+			query := parsed.Query()
+			query.Set("default_transaction_read_only", "on")
+			parsed.RawQuery = query.Encode()
+			dsn = parsed.String()
+		}
+
+	case read_only:
+		// Opening in read-only mode would otherwise create a missing database.
+		if _, err := os.Stat(target); err != nil {
+			return nil, err
+		}
+		dsn = fmt.Sprintf("file:%s?mode=ro", target)
+	}
+
+	connection, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	// sql.Open doesn't connect, checking whether the database can be opened.
+	if err := connection.Ping(); err != nil {
+		connection.Close()
+		return nil, err
+	}
+
+	return &Target{connection, driver == "postgres"}, nil
+}
+
+func (t *Target) close() error {
+	return t.connection.Close()
+}
+
+// Function takes in a statement with ? placeholders and returns
+// it with placeholders of the target's driver.
+func (t *Target) rebind(stmt string) string {
+
+	if !t.postgres {
+		return stmt
+	}
+
+	var builder strings.Builder
+	n := 0
+
+	for _, r := range stmt {
+		if r == '?' {
+			n++
+			fmt.Fprintf(&builder, "$%d", n)
+			continue
+		}
+		builder.WriteRune(r)
+	}
+
+	return builder.String()
+}
+
+// Function checks whether the target's destinations table has the columns merge writes to.
+// Returns an error describing the mismatch if it doesn't.
+func (t *Target) verify() error {
+
+	query := "SELECT name, \"notnull\" = 1 AND dflt_value IS NULL AND pk = 0 FROM pragma_table_info('destinations')"
+	expected := target_columns
+
+	if t.postgres {
+		query = `SELECT column_name, is_nullable = 'NO' AND column_default IS NULL FROM information_schema.columns
+			WHERE table_schema = current_schema() AND table_name = 'destinations'`
+		expected = append(expected[:len(expected):len(expected)], "coordinates")
+	}
+
+	rows, err := t.connection.Query(query)
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	var (
+		found = map[string]bool{}
+		// Columns that have to be set but aren't written by merge.
+		required []string
+		// Temporary values to read the row to.
+		tmp_name     string
+		tmp_required bool
+	)
+
+	for rows.Next() {
+
+		if err := rows.Scan(&tmp_name, &tmp_required); err != nil {
+			return err
+		}
+
+		found[tmp_name] = true
+
+		if tmp_required && !sliceContains(&tmp_name, expected) {
+			required = append(required, tmp_name)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// No columns are returned for a missing table.
+	if len(found) == 0 {
+		return errors.New("target database has no destinations table, create it with bootstrap-target")
+	}
+
+	var missing []string
+	for _, column := range expected {
+		if !found[column] {
+			missing = append(missing, column)
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("destinations table of the target database is missing columns: %s", strings.Join(missing, ", "))
+	}
+
+	if len(required) > 0 {
+		return fmt.Errorf("destinations table of the target database has required columns merge doesn't write: %s",
+			strings.Join(required, ", "))
+	}
+
+	return nil
+}
+
+// Function takes in an interface that contains a QueryRow method (sql.Tx or sql.DB)
+// connected to the target and an attraction's id. Returns a reference to the Attraction
+// in the target, or nil if it doesn't exist, and an error if it occurs.
+func (t *Target) readAttraction(connection interface {
+	QueryRow(string, ...interface{}) *sql.Row
+}, id string) (*Attraction, error) {
+
+	query := "SELECT category, description, location, copyright FROM destinations WHERE id = ?"
+	if t.postgres {
+		query = "SELECT category, description::text, location::text, copyright FROM destinations WHERE id = ?"
+	}
+
+	a := Attraction{id: id}
+
+	err := connection.QueryRow(t.rebind(query), id).Scan(&a.category, &a.description, &a.location, &a.copyright)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &a, nil
+}
+
+// Function takes in an interface that contains an Exec method (sql.Tx or sql.DB) connected
+// to the target, an attraction's id, the values of its row and whether the row already exists.
+// The row is inserted or updated, PostgreSQL targets also get the coordinates from the location.
+// An error is returned if it occurs.
+func (t *Target) write(connection interface {
+	Exec(string, ...interface{}) (sql.Result, error)
+}, id string, row TargetRow, exists bool) error {
+
+	if !t.postgres {
+		stmt := "INSERT INTO destinations (category, description, location, copyright, id) VALUES (?, ?, ?, ?, ?)"
+		if exists {
+			stmt = "UPDATE destinations SET category = ?, description = ?, location = ?, copyright = ? WHERE id = ?"
+		}
+		_, err := connection.Exec(stmt, row.Category, row.Description, row.Location, row.Copyright, id)
+		return err
+	}
+
+	attr := row.attraction(id)
+	coords := attr.coordinates()
+
+	stmt := `INSERT INTO destinations (category, description, location, copyright, coordinates, id)
+		VALUES (?, ?::jsonb, ?::jsonb, ?, ST_SetSRID(ST_MakePoint(?, ?), 4326)::geography, ?)`
+	if exists {
+		stmt = `UPDATE destinations SET category = ?, description = ?::jsonb, location = ?::jsonb, copyright = ?,
+			coordinates = ST_SetSRID(ST_MakePoint(?, ?), 4326)::geography WHERE id = ?`
+	}
+
+	_, err := connection.Exec(t.rebind(stmt), row.Category, row.Description, row.Location, row.Copyright,
+		coords.Longitude, coords.Latitude, id)
+
+	return err
+}
+
+// Function takes in a url of an empty database and creates the schema merge
+// expects in the target database. PostgreSQL databases may already have extensions,
+// e.g. PostGIS. Returns a string with the execution result.
+func bootstrapTarget(target string) string {
+
+	t, err := openTarget(target, false)
+	if err != nil {
+		return fmt.Sprintf("Failed to open target database: %s", err.Error())
+	}
+
+	defer t.close()
+
+	query, schema := "SELECT COUNT(*) FROM sqlite_master", sqlite_target_schema
+	if t.postgres {
+		query, schema = postgres_tables_query, postgres_target_schema
+	}
+
+	var tables int
+	if err := t.connection.QueryRow(query).Scan(&tables); err != nil {
+		return fmt.Sprintf("Failed to open target database: %s", err.Error())
+	}
+
+	// Existing data is never altered.
+	if tables > 0 {
+		return fmt.Sprintf("Target database %s is not empty", redactTarget(target))
+	}
+
+	tx, err := t.connection.Begin()
+	if err != nil {
+		return fmt.Sprintf("Failed to create schema: %s", err.Error())
+	}
+
+	for _, stmt := range schema {
+		if _, err := tx.Exec(stmt); err != nil {
+			tx.Rollback()
+			return fmt.Sprintf("Failed to create schema: %s", err.Error())
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Sprintf("Failed to create schema: %s", err.Error())
+	}
+
+	return fmt.Sprintf("Created destinations table in %s", redactTarget(target))
+}
+
+// Function takes in references to two Attractions and returns whether their values
+// in the target database are the same. Description and location are compared as json
+// because PostgreSQL doesn't keep the formatting of JSONB values.
+func sameValues(a, b *Attraction) bool {
+	return a.category == b.category && sameCopyright(a, b) &&
+		canonicalJson(a.description) == canonicalJson(b.description) &&
+		canonicalJson(a.location) == canonicalJson(b.location)
+}
+
+// Function takes in references to two Attractions and returns whether their copyright is
+// the same, a missing copyright is the same as an empty one.
+func sameCopyright(a, b *Attraction) bool {
+	return a.copyright.String == b.copyright.String
+}
+
+// Function takes in a json string and returns it re-encoded with sorted
+// keys and no whitespace, or as is if it isn't valid json.
+func canonicalJson(str string) string {
+
+	var value interface{}
+	if err := json.Unmarshal([]byte(str), &value); err != nil {
+		return str
+	}
+
+	data, _ := json.Marshal(value)
+
+	return string(data)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	// Registering png decoder for image.Decode, jpeg is registered by image/jpeg.
+	_ "image/png"
+
+	"github.com/gorilla/mux"
+)
+
+// Content types of images that can be uploaded.
+var upload_types = []string{"image/jpeg", "image/png"}
+
+// Route handler to add an uploaded image to attraction's gallery instead of providing an url.
+// Function takes in the standart handler parameters http.ResponseWriter and a reference
+// to a http.Request with a multipart "image" file and caption, author, licence and primary
+// fields. The image is processed the same way merge processes downloaded images and stored
+// in the image store together with its perceptual hash and placeholders, so merge doesn't
+// process it again. Reponds with an error or its position, hash and warnings about the image.
+func (s *Server) uploadImage(writer http.ResponseWriter, request *http.Request) {
+
+	id := mux.Vars(request)["id"]
+
+	// see store.go
+	attraction, err := s.store.get(id)
+	if err != nil {
+		respondError(writer, request, err)
+		return
+	}
+	if attraction == nil {
+		respondError(writer, request, apiError(http.StatusNotFound, code_not_found))
+		return
+	}
+
+	// Bodies larger than the limit fail to parse instead of filling up the memory.
+	request.Body = http.MaxBytesReader(writer, request.Body, config.Upload.MaxSize)
+
+	if err := request.ParseMultipartForm(config.Upload.MaxSize); err != nil {
+		respondError(writer, request, apiError(http.StatusRequestEntityTooLarge, code_body_too_large, config.Upload.MaxSize))
+		return
+	}
+
+	defer request.MultipartForm.RemoveAll()
+
+	info := GalleryImage{
+		caption: createNullString(strings.TrimSpace(request.FormValue("caption"))),
+		author:  createNullString(strings.TrimSpace(request.FormValue("author"))),
+		licence: request.FormValue("licence"),
+		primary: request.FormValue("primary") == "true",
+	}
+
+	// see gallery.go
+	var invalid ValidationErrors
+	if validateLicence(&invalid, "", info.licence, info.author.String); len(invalid) > 0 {
+		respondError(writer, request, invalid)
+		return
+	}
+
+	file, _, err := request.FormFile("image")
+	if err != nil {
+		respondError(writer, request, apiError(http.StatusBadRequest, code_missing_image))
+		return
+	}
+
+	defer file.Close()
+
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		respondError(writer, request, apiError(http.StatusBadRequest, code_image_read_failed))
+		return
+	}
+
+	// Detecting the type from the contents since the declared type can't be trusted.
+	if content_type := http.DetectContentType(data); !sliceContains(&content_type, upload_types) {
+		respondError(writer, request, apiError(http.StatusUnsupportedMediaType, code_unsupported_type, content_type, upload_types))
+		return
+	}
+
+	down := Downloadable{id: id, image: data, location: attraction.coordinates()}
+
+	// see retrieve.go
+	if rejected := processImage(&down); rejected != nil {
+		respondError(writer, request, rejected)
+		return
+	}
+
+	// Storing the processed image, see send.go for encoding.
+	encoded, err := encodeJpeg(down)
+	if err != nil {
+		respondError(writer, request, apiError(http.StatusInternalServerError, code_encode_failed))
+		return
+	}
+
+	// see imagestore.go
+	hash, err := s.images.put(encoded)
+	if err != nil {
+		respondError(writer, request, apiError(http.StatusInternalServerError, code_image_store_failed))
+		return
+	}
+
+	info.upload = createNullString(hash)
+	// Merge reads these instead of processing the image again, see retrieve.go
+	info.phash, info.blurhash, info.colour = createNullString(formatHash(down.hash)), createNullString(down.blurhash),
+		createNullString(down.colour)
+
+	// Adding the image to the end of the gallery, see store.go
+	position, err := s.store.addImage(id, info)
+	switch {
+	case err == errNotFound:
+		respondError(writer, request, apiError(http.StatusNotFound, code_not_found))
+		return
+	case err == errGalleryFull:
+		respondError(writer, request, apiError(http.StatusBadRequest, code_gallery_too_large, max_gallery_size))
+		return
+	case err != nil:
+		respondError(writer, request, err)
+		return
+	}
+
+	down.position = position
+
+	// see exif.go
+	respond(writer, http.StatusOK, map[string]interface{}{
+		"position": position,
+		"hash":     hash,
+		"warnings": checkLocations([]Downloadable{down}),
+	})
+}
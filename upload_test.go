@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"image/jpeg"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Function takes in a server, attraction's id, licence and jpeg bytes and
+// uploads them to the attraction's gallery. Returns the recorded response.
+func testUpload(t *testing.T, s *Server, id, licence string, data []byte) *httptest.ResponseRecorder {
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	writer.WriteField("author", "Ona")
+	writer.WriteField("licence", licence)
+	part, err := writer.CreateFormFile("image", "upload.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(data)
+	writer.Close()
+
+	request := httptest.NewRequest(http.MethodPost, v1_prefix+"/attractions/"+id+"/images", &body)
+	request.Header.Set("Content-Type", writer.FormDataContentType())
+
+	recorder := httptest.NewRecorder()
+	s.router.ServeHTTP(recorder, request)
+
+	return recorder
+}
+
+// Function returns jpeg bytes of a sharp image that passes the quality rules.
+func testJpeg(t *testing.T) []byte {
+
+	var buffer bytes.Buffer
+	if err := jpeg.Encode(&buffer, checkerboard(1200, 800), &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+// Merge sends and saves uploads as they were stored when they were uploaded.
+func TestUploadIsProcessedOnce(t *testing.T) {
+
+	s := testServer()
+	s.images = newImageStore(t.TempDir())
+
+	a := testAttraction("vilnius")
+	a.images = nil
+	if err := s.store.add(&a); err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := testUpload(t, s, "vilnius", "all-rights-reserved", testJpeg(t))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("upload responded with %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	var response struct{ Hash string }
+	json.Unmarshal(recorder.Body.Bytes(), &response)
+
+	stored, err := s.images.get(response.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	attraction, err := s.store.get("vilnius")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img := attraction.images[0]; !img.phash.Valid || !img.blurhash.Valid || !img.colour.Valid {
+		t.Fatalf("uploaded image is %+v, want its hash and placeholders", img)
+	}
+
+	var (
+		downs  []Downloadable
+		failed []Failure
+	)
+	getUrls([]Attraction{*attraction}, s.images, &downs, &failed)
+	process(&downs, &failed)
+
+	if len(downs) != 1 || len(failed) != 0 {
+		t.Fatalf("processed %d uploads, failed %v", len(downs), failed)
+	}
+	if downs[0].blurhash != attraction.images[0].blurhash.String {
+		t.Fatalf("blurhash is %q after merge, want %q", downs[0].blurhash, attraction.images[0].blurhash.String)
+	}
+
+	data, err := encodeJpeg(downs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, stored) {
+		t.Fatal("merge encoded the upload again")
+	}
+
+	// Watermarked uploads have to be encoded with the attribution.
+	attraction.images[0].licence = "cc-by"
+	downs, failed = nil, nil
+	getUrls([]Attraction{*attraction}, s.images, &downs, &failed)
+	process(&downs, &failed)
+
+	if data, err := encodeJpeg(downs[0]); err != nil || bytes.Equal(data, stored) {
+		t.Fatalf("watermarked upload wasn't encoded again, %v", err)
+	}
+}
+
+func TestUploadToFullGallery(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store AttractionStore) {
+
+		a := testAttraction("vilnius")
+		if err := store.add(&a); err != nil {
+			t.Fatal(err)
+		}
+
+		upload := GalleryImage{upload: createNullString("ab12"), author: createNullString("Ona"), licence: "cc-by"}
+		for ind := 1; ind < max_gallery_size; ind++ {
+			if _, err := store.addImage("vilnius", upload); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if _, err := store.addImage("vilnius", upload); err != errGalleryFull {
+			t.Fatalf("addImage to a full gallery returned %v, want errGalleryFull", err)
+		}
+
+		s := testServer()
+		s.store, s.images = store, newImageStore(t.TempDir())
+
+		recorder := testUpload(t, s, "vilnius", "cc-by", testJpeg(t))
+		if recorder.Code != http.StatusBadRequest || !bytes.Contains(recorder.Body.Bytes(), []byte(code_gallery_too_large)) {
+			t.Fatalf("upload to a full gallery responded with %d: %s", recorder.Code, recorder.Body.String())
+		}
+	})
+}
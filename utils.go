@@ -4,8 +4,8 @@ import (
 	"database/sql"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"io"
+	"math"
 	"net/http"
 	"strings"
 )
@@ -21,6 +21,17 @@ func sliceContains(s *string, slice []string) bool {
 	return false
 }
 
+// Function takes in an int and a slice of ints and
+// returns a bool whether the slice contains that int.
+func intContains(i int, slice []int) bool {
+	for _, item := range slice {
+		if item == i {
+			return true
+		}
+	}
+	return false
+}
+
 // Function takes in a string and returns a sql.NullString
 // with validity according to it's contents.
 func createNullString(str string) sql.NullString {
@@ -67,9 +78,25 @@ func compareID(a, b string) float32 {
 	return 2.0 * intersect / float32(lena+lenb-2)
 }
 
+// Function takes in two Coordinates and returns the great-circle
+// distance between them in kilometers.
+func distance(a, b Coordinates) float64 {
+
+	const earth_radius = 6371.0
+
+	lat1, lat2 := float64(a.Latitude)*math.Pi/180, float64(b.Latitude)*math.Pi/180
+	dlat := lat2 - lat1
+	dlon := float64(b.Longitude-a.Longitude) * math.Pi / 180
+
+	// Haversine formula.
+	h := math.Sin(dlat/2)*math.Sin(dlat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dlon/2)*math.Sin(dlon/2)
+
+	return 2 * earth_radius * math.Asin(math.Sqrt(h))
+}
+
 // Function validates the json body, takes in a reference to a http.Request and an interface of an object
-// (reference) to which unmarshall the json. Returning status code and a string with status.
-func validateJson(request *http.Request, target interface{}) (int, string) {
+// (reference) to which unmarshall the json. Returns an ApiError if the body can't be decoded, see messages.go
+func validateJson(request *http.Request, target interface{}) error {
 
 	decoder := json.NewDecoder(request.Body)
 	// Body should contain only the necessary fields.
@@ -82,20 +109,24 @@ func validateJson(request *http.Request, target interface{}) (int, string) {
 		switch {
 
 		case errors.As(err, &unmarshalTypeError):
-			return http.StatusBadRequest, fmt.Sprintf("Request body contains an invalid value for the %q field (at position %d)", unmarshalTypeError.Field, unmarshalTypeError.Offset)
+			return apiError(http.StatusBadRequest, code_invalid_type, unmarshalTypeError.Field, unmarshalTypeError.Offset)
 
 		case strings.HasPrefix(err.Error(), "json: unknown field "):
 			fieldName := strings.TrimPrefix(err.Error(), "json: unknown field ")
-			return http.StatusBadRequest, fmt.Sprintf("Request body contains unknown field%q", fieldName)
+			return apiError(http.StatusBadRequest, code_unknown_field, fieldName)
 
 		case errors.Is(err, io.EOF):
-			return http.StatusBadRequest, "Request body is empty"
+			return apiError(http.StatusBadRequest, code_empty_body)
+
+		// Returned by http.MaxBytesReader once the body exceeds its limit.
+		case err.Error() == "http: request body too large":
+			return apiError(http.StatusRequestEntityTooLarge, code_json_too_large)
 
 		default:
-			return http.StatusBadRequest, err.Error()
+			return apiError(http.StatusBadRequest, code_invalid_json)
 		}
 	}
 
-	return http.StatusOK, "wh"
+	return nil
 
 }
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"strings"
+)
+
+// Codes of validation errors. Codes are stable, clients use them to localise
+// messages, existing codes are never renamed or reused for other errors.
+// Messages of the codes are in messages.go
+const (
+	// Value is shorter than allowed: description.info, description.name.
+	code_too_short = "too_short"
+	// City is too short or contains characters outside the lithuanian alphabet.
+	code_invalid_city = "invalid_city"
+	// Open hours don't match the HH:MM-HH:MM pattern: description.hours.wkd, std and snd.
+	code_invalid_hours = "invalid_hours"
+	// Category isn't one of viable_categories.
+	code_invalid_category = "invalid_category"
+	// Coordinate is outside of Lithuania: location.coordinates.latitude and longitude.
+	code_outside_lithuania = "outside_lithuania"
+	// Both image and images are set.
+	code_image_conflict = "image_conflict"
+	// Gallery contains more than max_gallery_size images.
+	code_gallery_too_large = "gallery_too_large"
+	// Image url doesn't start with http:// or https://: images[i].url
+	code_invalid_url = "invalid_url"
+	// Licence isn't one of viable_licences: images[i].licence
+	code_invalid_licence = "invalid_licence"
+	// Author is missing for a licence that requires attribution: images[i].author
+	code_author_required = "author_required"
+	// More than one image of the gallery is primary: images[i].primary
+	code_multiple_primary = "multiple_primary"
+	// Required value is missing, see schema.go
+	code_required = "required"
+	// Value has a wrong json type, e.g. a string instead of a number.
+	code_wrong_type = "wrong_type"
+)
+
+// Error of a single invalid value.
+type FieldError struct {
+	// Path of the value in the request body, e.g. description.hours.wkd or images[0].url
+	Path    string `json:"path"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Values formatted into the message.
+	args []interface{}
+}
+
+// Every invalid value of a request, used as an error.
+type ValidationErrors []FieldError
+
+// Function takes in a path of the invalid value, one of the validation codes and values
+// formatted into its message, and appends the error with the english message.
+func (v *ValidationErrors) add(path, code string, args ...interface{}) {
+	*v = append(*v, FieldError{path, code, message(supported_languages[0], code, args...), args})
+}
+
+// Function takes in a language and returns a copy of the errors with messages in it.
+func (v ValidationErrors) localise(lang string) ValidationErrors {
+
+	localised := make(ValidationErrors, 0, len(v))
+	for _, e := range v {
+		e.Message = message(lang, e.Code, e.args...)
+		localised = append(localised, e)
+	}
+
+	return localised
+}
+
+// Function takes in a path and returns whether the errors contain an
+// error of the value or of a value that contains it.
+func (v ValidationErrors) covers(path string) bool {
+
+	for _, e := range v {
+		if e.Path == path || strings.HasPrefix(path, e.Path+".") || strings.HasPrefix(path, e.Path+"[") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Function returns paths and messages of all errors joined by semicolons.
+func (v ValidationErrors) Error() string {
+
+	lines := make([]string, 0, len(v))
+	for _, e := range v {
+		// Errors of the whole body have no path.
+		if e.Path == "" {
+			lines = append(lines, e.Message)
+			continue
+		}
+		lines = append(lines, e.Path+": "+e.Message)
+	}
+
+	return strings.Join(lines, "; ")
+}
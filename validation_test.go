@@ -0,0 +1,110 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Function returns a RawAttraction that passes validation.
+func testRawAttraction() RawAttraction {
+
+	var ra RawAttraction
+	ra.Category = "nature"
+	ra.Description.Name = "Gražuolis"
+	ra.Description.Info = "Labai graži vieta prie upės su takais"
+	ra.Description.Hours.Wkd, ra.Description.Hours.Std, ra.Description.Hours.Snd = "08:00-20:00", "08:00-20:00", "08:00-20:00"
+	ra.Location.City = "Vilnius"
+	ra.Location.Coordinates = Coordinates{54.7, 25.3}
+
+	return ra
+}
+
+// Lengths are counted in bytes, lithuanian letters take two.
+func TestValidateCountsBytes(t *testing.T) {
+
+	for _, test := range []struct {
+		name  string
+		valid bool
+	}{
+		{"Abcd", true},
+		{"Abc", false},
+		{"Ežė", true},
+		{"Žž", true},
+		{"Ą", false},
+	} {
+		ra := testRawAttraction()
+		ra.Description.Name = test.name
+		if err := ra.validate(); (err == nil) != test.valid {
+			t.Errorf("validate of name %q returned %v", test.name, err)
+		}
+	}
+}
+
+func TestValidationErrorsOfNestedFields(t *testing.T) {
+
+	ra := testRawAttraction()
+	ra.Description.Hours.Std = "9-18"
+	ra.Location.Coordinates.Longitude = 30
+	ra.Images = []RawImage{
+		{Url: "https://example.com/1.jpg", Author: "Ona", Licence: "cc-by", Primary: true},
+		{Url: "ftp://example.com/2.jpg", Licence: "cc-by", Primary: true},
+	}
+
+	err := ra.validate()
+	errs, ok := err.(ValidationErrors)
+	if !ok {
+		t.Fatalf("validate returned %v", err)
+	}
+
+	expected := []FieldError{
+		{Path: "description.hours.std", Code: code_invalid_hours, Message: "Open hours must match HH:MM-HH:MM"},
+		{Path: "location.coordinates.longitude", Code: code_outside_lithuania, Message: "Location is outside of Lithuania"},
+		{Path: "images[1].url", Code: code_invalid_url, Message: "Image url must start with http:// or https://"},
+		{Path: "images[1].author", Code: code_author_required, Message: "Author is required"},
+		{Path: "images[1].primary", Code: code_multiple_primary, Message: "Only one image can be primary"},
+	}
+
+	if len(errs) != len(expected) {
+		t.Fatalf("validate returned %d errors: %v", len(errs), errs)
+	}
+	for ind, e := range expected {
+		if errs[ind].Path != e.Path || errs[ind].Code != e.Code || errs[ind].Message != e.Message {
+			t.Errorf("error %d is %+v, want %+v", ind, errs[ind], e)
+		}
+	}
+
+	if localised := errs.localise("lt"); localised[2].Message != "Nuotraukos nuoroda turi prasidėti http:// arba https://" {
+		t.Errorf("lithuanian message is %q", localised[2].Message)
+	}
+}
+
+func TestAddRespondsWithValidationErrors(t *testing.T) {
+
+	body := `{"category":"nature","description":{"name":"Abc","hours":{"wkd":"08:00-20:00","std":"08:00-20:00","snd":"08:00-20:00"},
+		"info":"Labai graži vieta prie upės su takais"},
+		"location":{"city":"Vilnius","coordinates":{"latitude":54.7,"longitude":25.3}},
+		"images":[{"url":"https://example.com/1.jpg","licence":"cc-by"}]}`
+
+	request := httptest.NewRequest(http.MethodPost, v1_prefix+"/add", strings.NewReader(body))
+	request.Header.Set("Accept-Language", "lt")
+
+	recorder := httptest.NewRecorder()
+	testServer().router.ServeHTTP(recorder, request)
+
+	if recorder.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("add responded with %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	errs := responseErrors(t, recorder)
+	if e := errs["description.name"]; e.Code != code_too_short || e.Message != "Simbolių skaičius turi būti didesnis nei 3" {
+		t.Errorf("description.name error is %+v", e)
+	}
+	if e := errs["images[0].author"]; e.Code != code_author_required || e.Message != "Autorius privalomas" {
+		t.Errorf("images[0].author error is %+v", e)
+	}
+	if len(errs) != 2 {
+		t.Errorf("add responded with errors %v", errs)
+	}
+}
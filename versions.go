@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Prefix of the first version of the API.
+const v1_prefix = "/v1"
+
+// Unversioned routes are aliases of the first version kept for clients written before
+// versioning, e.g. the Vue form. They're deprecated since deprecated_at and removed at sunset_at.
+var (
+	deprecated_at = time.Date(2026, time.November, 1, 0, 0, 0, 0, time.UTC)
+	sunset_at     = time.Date(2027, time.May, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// Paths of the first version that existed before versioning and have unversioned aliases,
+// routes added since are only served under their version's prefix.
+var unversioned_paths = []string{"/add", "/check"}
+
+// Handler set of a version of the API. Versions are registered under their own prefix and
+// share the server's store, a new version only adds the routes whose payload changes
+// and reuses the handlers of the previous version for the rest.
+type ApiVersion struct {
+	prefix string
+	routes []Route
+}
+
+// Single route of an ApiVersion, the path is relative to the version's prefix.
+type Route struct {
+	method  string
+	path    string
+	handler http.HandlerFunc
+	// Query parameters the route requires, in pairs of a name and a pattern.
+	queries []string
+}
+
+// Function returns every version of the API the server responds to.
+func (s *Server) versions() []ApiVersion {
+	return []ApiVersion{s.v1()}
+}
+
+// Function takes in a reference to a mux.Router and registers the routes of the version on it.
+func (v ApiVersion) register(router *mux.Router) {
+
+	for _, route := range v.routes {
+
+		r := router.HandleFunc(route.path, route.handler).Methods(route.method)
+
+		if len(route.queries) > 0 {
+			r.Queries(route.queries...)
+		}
+	}
+}
+
+// Function takes in paths of the version's routes and returns a copy of the version with only
+// those routes, without a prefix and with handlers that respond with deprecation headers
+// pointing to the versioned routes.
+func (v ApiVersion) deprecated(paths []string) ApiVersion {
+
+	alias := ApiVersion{routes: make([]Route, 0, len(paths))}
+
+	for _, route := range v.routes {
+		if !sliceContains(&route.path, paths) {
+			continue
+		}
+		route.handler = deprecate(route.handler, v.prefix)
+		alias.routes = append(alias.routes, route)
+	}
+
+	return alias
+}
+
+// Key of the request context value that marks requests of deprecated aliases.
+type legacyKey struct{}
+
+// Function takes in a reference to a http.Request and returns whether it was made to a deprecated alias.
+func isLegacy(request *http.Request) bool {
+	legacy, _ := request.Context().Value(legacyKey{}).(bool)
+	return legacy
+}
+
+// Function takes in a route handler and the prefix of the route that replaces it and returns
+// a handler that adds the Deprecation (RFC 9745), Sunset (RFC 8594) and Link headers.
+// Requests are marked as legacy, see isLegacy.
+func deprecate(handler http.HandlerFunc, prefix string) http.HandlerFunc {
+
+	return func(writer http.ResponseWriter, request *http.Request) {
+
+		header := writer.Header()
+		header.Set("Deprecation", "@"+strconv.FormatInt(deprecated_at.Unix(), 10))
+		header.Set("Sunset", sunset_at.Format(http.TimeFormat))
+		successor := prefix + request.URL.Path
+		if request.URL.RawQuery != "" {
+			successor += "?" + request.URL.RawQuery
+		}
+		header.Set("Link", "<"+successor+">; rel=\"successor-version\"")
+
+		handler(writer, request.WithContext(context.WithValue(request.Context(), legacyKey{}, true)))
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOnlyOldRoutesHaveAliases(t *testing.T) {
+
+	s := testServer()
+
+	for path, status := range map[string]int{
+		"/check?name=Vilnius":    http.StatusOK,
+		"/v1/check?name=Vilnius": http.StatusOK,
+		"/attractions":           http.StatusNotFound,
+		"/v1/attractions":        http.StatusOK,
+	} {
+		recorder := httptest.NewRecorder()
+		s.router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+
+		if recorder.Code != status {
+			t.Errorf("GET %s responded with %d, want %d", path, recorder.Code, status)
+		}
+	}
+
+	for _, test := range []struct {
+		path                   string
+		documented, deprecated bool
+	}{
+		{"/add", true, true},
+		{"/check", true, true},
+		{"/v1/add", true, false},
+		{"/attractions", false, false},
+	} {
+		operations, ok := api_spec.Paths[test.path]
+		if ok != test.documented {
+			t.Errorf("%s is documented: %v, want %v", test.path, ok, test.documented)
+		}
+		for method, operation := range operations {
+			if operation.Deprecated != test.deprecated {
+				t.Errorf("%s %s is deprecated: %v, want %v", method, test.path, operation.Deprecated, test.deprecated)
+			}
+		}
+	}
+}
+
+func TestDeprecatedLinkKeepsQuery(t *testing.T) {
+
+	recorder := httptest.NewRecorder()
+	testServer().router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/check?name=Vilnius", nil))
+
+	if link := recorder.Header().Get("Link"); link != `</v1/check?name=Vilnius>; rel="successor-version"` {
+		t.Fatalf("Link is %q", link)
+	}
+	if recorder.Header().Get("Deprecation") == "" || recorder.Header().Get("Sunset") == "" {
+		t.Fatal("alias responded without deprecation headers")
+	}
+}
+
+// Bodies written for the API before versioning name fields in any case.
+func TestLegacyAddFoldsCase(t *testing.T) {
+
+	body := `{"Category":"nature","Description":{"Name":"Gražuolis","Hours":{"Wkd":"08:00-20:00","STD":"08:00-20:00",
+		"snd":"08:00-20:00"},"Info":"Labai graži vieta prie upės su takais"},
+		"Location":{"City":"Vilnius","Coordinates":{"Latitude":54.7,"Longitude":25.3}},
+		"Images":[{"Url":"https://example.com/1.jpg","Author":"Ona","Licence":"cc-by"}]}`
+
+	s := testServer()
+
+	recorder := httptest.NewRecorder()
+	s.router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, v1_prefix+"/add", strings.NewReader(body)))
+	if errs := responseErrors(t, recorder); recorder.Code != http.StatusUnprocessableEntity || errs["Category"].Code != code_unknown_field {
+		t.Fatalf("/v1/add responded with %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	recorder = httptest.NewRecorder()
+	s.router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/add", strings.NewReader(body)))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("/add responded with %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	a, err := s.store.get(toID("Gražuolis"))
+	if err != nil || a == nil {
+		t.Fatalf("get returned %+v, %v", a, err)
+	}
+
+	// Stored with the names of the schema.
+	if !strings.HasPrefix(a.description, `{"name":"Gražuolis","hours":{"wkd":"08:00-20:00"`) ||
+		a.location != `{"city":"Vilnius","coordinates":{"latitude":54.7,"longitude":25.3}}` {
+		t.Fatalf("stored description %s and location %s", a.description, a.location)
+	}
+	if len(a.images) != 1 || a.images[0].author.String != "Ona" {
+		t.Fatalf("stored gallery is %+v", a.images)
+	}
+}
+
+func TestFoldKeysKeepsExactNames(t *testing.T) {
+
+	folded := attraction_schema.foldKeys(map[string]interface{}{"category": "nature", "Category": "museums", "Unknown": 1})
+	if !reflect.DeepEqual(folded, map[string]interface{}{"category": "nature", "Category": "museums", "Unknown": 1}) {
+		t.Fatalf("folded keys are %v", folded)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"strings"
+	"sync"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// Corners the attribution can be placed in.
+var watermark_corners = []string{"top-left", "top-right", "bottom-left", "bottom-right"}
+
+// Smallest font size in pixels long attributions are scaled down to before they're truncated.
+const min_watermark_size = 10
+
+// Go Regular is embedded in the binary and contains Lithuanian glyphs (ą, č, ę, ė, į, š, ų, ū, ž).
+// It's parsed on first use, see watermarkFont.
+var (
+	watermark_font       *opentype.Font
+	watermark_font_error error
+	watermark_font_once  sync.Once
+)
+
+// Function returns the parsed watermark font and an error if it couldn't be parsed.
+func watermarkFont() (*opentype.Font, error) {
+	watermark_font_once.Do(func() {
+		watermark_font, watermark_font_error = opentype.Parse(goregular.TTF)
+	})
+	return watermark_font, watermark_font_error
+}
+
+// Function takes in a reference to a processed Downloadable and renders its
+// attribution over the image if it's configured for the image's licence.
+// An error is returned if it occurs.
+func applyWatermark(down *Downloadable) error {
+
+	rule, ok := config.Watermark[down.info.licence]
+	text := down.info.attribution()
+
+	if !ok || text == "" {
+		return nil
+	}
+
+	bounds := down.decoded_img.Bounds()
+
+	// Long attributions are scaled down and truncated to fit the image's width.
+	face, size, text, err := fitWatermark(text, rule.Size, bounds.Dx())
+	if err != nil {
+		return err
+	}
+
+	defer face.Close()
+
+	// Copying the image so it can be drawn on.
+	dst := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(dst, dst.Bounds(), down.decoded_img, bounds.Min, draw.Src)
+
+	metrics := face.Metrics()
+	padding := int(math.Ceil(size / 2))
+	width := watermarkWidth(face, text, size)
+	height := (metrics.Ascent + metrics.Descent).Ceil() + padding
+
+	// Placing the caption box in the configured corner.
+	x, y := 0, 0
+	if rule.Corner == "top-right" || rule.Corner == "bottom-right" {
+		x = dst.Bounds().Dx() - width
+	}
+	if rule.Corner == "bottom-left" || rule.Corner == "bottom-right" {
+		y = dst.Bounds().Dy() - height
+	}
+
+	alpha := uint8(math.Round(rule.Opacity * 255))
+
+	// Darkening the area behind the text so it's readable on light images.
+	box := image.Rect(x, y, x+width, y+height)
+	draw.DrawMask(dst, box, image.NewUniform(color.Black), image.Point{}, image.NewUniform(color.Alpha{alpha / 2}), image.Point{}, draw.Over)
+
+	drawer := font.Drawer{
+		Dst:  dst,
+		Src:  image.NewUniform(color.NRGBA{255, 255, 255, alpha}),
+		Face: face,
+		Dot:  fixed.P(x+padding, y+padding/2+metrics.Ascent.Ceil()),
+	}
+	drawer.DrawString(text)
+
+	down.decoded_img = dst
+	// Processed uploads have to be encoded again with the attribution.
+	down.encoded = nil
+
+	return nil
+}
+
+// Function takes in an attribution, the configured font size and the width of the image.
+// Returns a font.Face, its size and the text that fit the width and an error if it occurs.
+// The size is reduced down to min_watermark_size, text that still doesn't fit is truncated.
+func fitWatermark(text string, size float64, max_width int) (font.Face, float64, string, error) {
+
+	parsed, err := watermarkFont()
+	if err != nil {
+		return nil, 0, "", err
+	}
+
+	for {
+
+		face, err := opentype.NewFace(parsed, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+		if err != nil {
+			return nil, 0, "", err
+		}
+
+		width := watermarkWidth(face, text, size)
+		if width <= max_width {
+			return face, size, text, nil
+		}
+
+		if size > min_watermark_size {
+			// Hinting changes widths a little, so the result is measured again.
+			size = math.Max(min_watermark_size, math.Floor(size*float64(max_width)/float64(width)))
+			face.Close()
+			continue
+		}
+
+		// Dropping characters until the text and an ellipsis fit.
+		runes := []rune(strings.TrimSuffix(text, "…"))
+		for len(runes) > 0 && watermarkWidth(face, string(runes)+"…", size) > max_width {
+			runes = runes[:len(runes)-1]
+		}
+
+		return face, size, strings.TrimRight(string(runes), " ") + "…", nil
+	}
+}
+
+// Function takes in a font.Face, text and font size and returns the width of the caption box in pixels.
+func watermarkWidth(face font.Face, text string, size float64) int {
+	return font.MeasureString(face, text).Ceil() + int(math.Ceil(size/2))*2
+}